@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/devaloi/promptkit"
+)
+
+func graphCmd() *cobra.Command {
+	var (
+		dirs   []string
+		chains []string
+		format string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "graph",
+		Short: "Print the dependency graph of templates, includes and chains",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			g, err := loadGraph(dirs, chains)
+			if err != nil {
+				return err
+			}
+
+			switch format {
+			case "dot":
+				fmt.Print(g.DOT())
+			case "mermaid":
+				fmt.Print(g.Mermaid())
+			default:
+				return fmt.Errorf("unknown format %q (want dot or mermaid)", format)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&dirs, "dir", "d", []string{promptkit.DefaultTemplateDir}, "template directory (repeat to layer directories; later ones override earlier ones)")
+	cmd.Flags().StringArrayVar(&chains, "chain", nil, "chain file to include (default: every .yaml file in the template directories)")
+	cmd.Flags().StringVar(&format, "format", "dot", "output format: dot or mermaid")
+	return cmd
+}
+
+func impactCmd() *cobra.Command {
+	var (
+		dirs   []string
+		chains []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "impact <file|name>",
+		Short: "List the templates and chains affected by changing a template or include",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			g, err := loadGraph(dirs, chains)
+			if err != nil {
+				return err
+			}
+
+			nodes := g.Find(args[0])
+			if len(nodes) == 0 {
+				return fmt.Errorf("no template, include or chain matches %q", args[0])
+			}
+
+			affected := g.Dependents(nodes...)
+			if len(affected) == 0 {
+				fmt.Printf("Nothing depends on %s.\n", args[0])
+				return nil
+			}
+			for _, n := range affected {
+				if n.Kind == promptkit.GraphChain {
+					fmt.Printf("%-9s %s (%s)\n", n.Kind, n.Name, n.Path)
+					continue
+				}
+				fmt.Printf("%-9s %s\n", n.Kind, n.Name)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&dirs, "dir", "d", []string{promptkit.DefaultTemplateDir}, "template directory (repeat to layer directories; later ones override earlier ones)")
+	cmd.Flags().StringArrayVar(&chains, "chain", nil, "chain file to include (default: every .yaml file in the template directories)")
+	return cmd
+}
+
+// loadGraph builds the dependency graph of the templates in dirs and of the
+// given chain files. Without chain files, every .yaml or .yml file in dirs
+// that parses as a chain is added.
+func loadGraph(dirs, chains []string) (*promptkit.Graph, error) {
+	reg, err := loadRegistry(dirs)
+	if err != nil {
+		return nil, fmt.Errorf("loading templates: %w", err)
+	}
+	g := reg.Graph()
+
+	if len(chains) > 0 {
+		for _, file := range chains {
+			def, err := promptkit.ParseChainFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			g.AddChain(def.Name, file, def.Templates())
+		}
+		return g, nil
+	}
+
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := filepath.Ext(file)
+			if entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+				return nil
+			}
+			def, err := promptkit.ParseChainFile(file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "skipping %s: %v\n", file, err)
+				return nil
+			}
+			g.AddChain(def.Name, file, def.Templates())
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("finding chains in %q: %w", dir, err)
+		}
+	}
+	return g, nil
+}
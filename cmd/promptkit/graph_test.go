@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// run executes the CLI with args and returns what it printed to stdout.
+func run(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	cmd := rootCmd()
+	cmd.SetArgs(args)
+	cmd.SetErr(io.Discard)
+	cmd.SetOut(io.Discard)
+	err = cmd.Execute()
+
+	w.Close()
+	out, _ := io.ReadAll(r)
+	return string(out), err
+}
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestImpact_IncludeFileName(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"includes/system_default.tmpl": "Be helpful.",
+		"ask.tmpl":                     `{{ template "system_default" }} {{ .question }}`,
+		"plain.tmpl":                   "plain",
+	})
+
+	for _, ref := range []string{"system_default.tmpl", "includes/system_default.tmpl", "system_default"} {
+		out, err := run(t, "impact", ref, "-d", dir)
+		if err != nil {
+			t.Fatalf("impact %s: %v", ref, err)
+		}
+		if !strings.Contains(out, "ask") || strings.Contains(out, "plain") {
+			t.Errorf("impact %s printed %q", ref, out)
+		}
+	}
+}
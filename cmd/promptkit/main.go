@@ -2,17 +2,17 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/spf13/cobra"
 
-	"github.com/devaloi/promptkit/internal/chain"
-	"github.com/devaloi/promptkit/internal/config"
-	"github.com/devaloi/promptkit/internal/engine"
-	"github.com/devaloi/promptkit/internal/registry"
-	"github.com/devaloi/promptkit/internal/validator"
+	"github.com/devaloi/promptkit"
 )
 
 func main() {
@@ -23,19 +23,25 @@ func main() {
 
 func rootCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "promptkit",
-		Short: "LLM prompt template engine",
-		Long:  "A template engine for LLM prompts with variable injection, validation, includes, and chaining.",
+		Use:     "promptkit",
+		Short:   "LLM prompt template engine",
+		Long:    "A template engine for LLM prompts with variable injection, validation, includes, and chaining.",
+		Version: promptkit.Version,
 	}
 
-	cmd.AddCommand(renderCmd(), validateCmd(), listCmd(), chainCmd())
+	cmd.AddCommand(renderCmd(), validateCmd(), lintCmd(), listCmd(), chainCmd(), graphCmd(), impactCmd(), checkOutputCmd())
 	return cmd
 }
 
 func renderCmd() *cobra.Command {
 	var (
-		dir     string
-		varFlag []string
+		dirs     []string
+		varFlag  []string
+		messages bool
+		strict   bool
+		tokFlag  []string
+		model    string
+		asJSON   bool
 	)
 
 	cmd := &cobra.Command{
@@ -43,8 +49,8 @@ func renderCmd() *cobra.Command {
 		Short: "Render a prompt template",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(_ *cobra.Command, args []string) error {
-			reg := registry.New()
-			if err := reg.LoadDir(dir); err != nil {
+			reg, err := loadRegistry(dirs)
+			if err != nil {
 				return fmt.Errorf("loading templates: %w", err)
 			}
 
@@ -55,38 +61,78 @@ func renderCmd() *cobra.Command {
 
 			vars := parseVars(varFlag)
 
-			if len(tmpl.Meta.RequiredVars) > 0 {
-				if err := validator.Validate(tmpl.Meta.RequiredVars, vars); err != nil {
-					return err
-				}
+			if err := promptkit.ValidateMeta(tmpl.Meta, vars); err != nil {
+				return err
 			}
 
-			result, err := engine.Render(tmpl.Content, vars, reg.Includes())
+			tokenizers, err := loadTokenizers(tokFlag)
+			if err != nil {
+				return err
+			}
+			opts := promptkit.RenderOptions{Strict: strict, Tokenizers: tokenizers, Model: model}
+
+			render := reg.RenderWithOptions
+			if messages {
+				render = reg.RenderMessagesWithOptions
+			}
+			result, err := render(tmpl.Ref(), vars, opts)
 			if err != nil {
 				return err
 			}
 
+			switch {
+			case asJSON:
+				return printJSON(renderOutput{
+					Template:     tmpl.Ref(),
+					Output:       result.Output,
+					Messages:     result.Messages,
+					Tokens:       result.Tokens,
+					Generation:   result.Generation,
+					OutputSchema: result.Meta.OutputSchema,
+				})
+			case messages:
+				return printJSON(result.Messages)
+			}
 			fmt.Print(result.Output)
 			return nil
 		},
 	}
 
-	cmd.Flags().StringVarP(&dir, "dir", "d", config.DefaultTemplateDir, "template directory")
+	cmd.Flags().StringArrayVarP(&dirs, "dir", "d", []string{promptkit.DefaultTemplateDir}, "template directory (repeat to layer directories; later ones override earlier ones)")
 	cmd.Flags().StringArrayVar(&varFlag, "var", nil, "variable in key=value format")
+	cmd.Flags().BoolVar(&messages, "messages", false, "print role-tagged chat messages as JSON")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the output, token count and generation settings as JSON")
+	cmd.Flags().StringVar(&model, "model", "", "model the prompt is for; selects the tokenizer and generation overrides")
+	cmd.Flags().BoolVar(&strict, "strict", false, "fail on missing keys and nil values")
+	cmd.Flags().StringArrayVar(&tokFlag, "tokenizer", nil, "tokenizer vocabulary in model=path format (.tiktoken or .model)")
 
 	return cmd
 }
 
+// renderOutput is the JSON printed by render --json.
+type renderOutput struct {
+	Template     string                  `json:"template"`
+	Output       string                  `json:"output"`
+	Messages     []promptkit.Message     `json:"messages,omitempty"`
+	Tokens       int                     `json:"tokens"`
+	Generation   promptkit.Generation    `json:"generation"`
+	OutputSchema *promptkit.OutputSchema `json:"output_schema,omitempty"`
+}
+
 func validateCmd() *cobra.Command {
-	var dir string
+	var (
+		dirs    []string
+		varFlag []string
+	)
 
 	cmd := &cobra.Command{
 		Use:   "validate <template>",
-		Short: "Validate required variables for a template",
+		Short: "Validate variables for a template",
+		Long:  "Show the variables a template requires and declares. With --var, check the given values against them.",
 		Args:  cobra.ExactArgs(1),
-		RunE: func(_ *cobra.Command, args []string) error {
-			reg := registry.New()
-			if err := reg.LoadDir(dir); err != nil {
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reg, err := loadRegistry(dirs)
+			if err != nil {
 				return fmt.Errorf("loading templates: %w", err)
 			}
 
@@ -95,80 +141,331 @@ func validateCmd() *cobra.Command {
 				return err
 			}
 
-			if len(tmpl.Meta.RequiredVars) == 0 {
+			if cmd.Flags().Changed("var") {
+				if err := promptkit.ValidateMeta(tmpl.Meta, parseVars(varFlag)); err != nil {
+					return err
+				}
+				fmt.Println("Variables are valid.")
+				return nil
+			}
+
+			required := tmpl.Meta.Required()
+			if len(required) == 0 && len(tmpl.Meta.Vars) == 0 {
 				fmt.Println("No required variables.")
 				return nil
 			}
 
-			fmt.Printf("Required variables for %q:\n", tmpl.Name)
-			for _, v := range tmpl.Meta.RequiredVars {
-				fmt.Printf("  - %s\n", v)
+			if len(required) > 0 {
+				fmt.Printf("Required variables for %q:\n", tmpl.Ref())
+				for _, v := range required {
+					fmt.Printf("  - %s\n", v)
+				}
+			}
+
+			if len(tmpl.Meta.Vars) > 0 {
+				fmt.Printf("Declared variables for %q:\n", tmpl.Ref())
+				names := make([]string, 0, len(tmpl.Meta.Vars))
+				for name := range tmpl.Meta.Vars {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					fmt.Printf("  - %s\n", describeVar(name, tmpl.Meta.Vars[name]))
+				}
 			}
 			return nil
 		},
 	}
 
-	cmd.Flags().StringVarP(&dir, "dir", "d", config.DefaultTemplateDir, "template directory")
+	cmd.Flags().StringArrayVarP(&dirs, "dir", "d", []string{promptkit.DefaultTemplateDir}, "template directory (repeat to layer directories; later ones override earlier ones)")
+	cmd.Flags().StringArrayVar(&varFlag, "var", nil, "variable in key=value format")
+	return cmd
+}
+
+// describeVar formats a declared variable as "name (type, default: x): description".
+func describeVar(name string, spec promptkit.VarSpec) string {
+	typ := spec.Type
+	if typ == "" {
+		typ = "any"
+	}
+	attrs := []string{typ}
+	if spec.Default != nil {
+		attrs = append(attrs, fmt.Sprintf("default: %v", spec.Default))
+	}
+	if len(spec.Enum) > 0 {
+		attrs = append(attrs, fmt.Sprintf("one of: %v", spec.Enum))
+	}
+
+	desc := fmt.Sprintf("%s (%s)", name, strings.Join(attrs, ", "))
+	if spec.Description != "" {
+		desc += ": " + spec.Description
+	}
+	return desc
+}
+
+func lintCmd() *cobra.Command {
+	var dirs []string
+
+	cmd := &cobra.Command{
+		Use:   "lint [template...]",
+		Short: "Check templates for undeclared and unused variables",
+		Long:  "Compare the variables each template references, including through includes, with those declared in its frontmatter. Lints every template when none are named.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reg, err := loadRegistry(dirs)
+			if err != nil {
+				return fmt.Errorf("loading templates: %w", err)
+			}
+
+			var templates []*promptkit.Template
+			if len(args) == 0 {
+				templates = allVersions(reg)
+			}
+			for _, name := range args {
+				tmpl, err := reg.Get(name)
+				if err != nil {
+					return err
+				}
+				templates = append(templates, tmpl)
+			}
+
+			problems := 0
+			for _, tmpl := range templates {
+				ref := tmpl.Ref()
+				includes, err := reg.IncludesFor(ref)
+				if err != nil {
+					return err
+				}
+				lineage, err := reg.Lineage(ref)
+				if err != nil {
+					return err
+				}
+				bodies := make([]string, len(lineage))
+				for i, t := range lineage {
+					bodies[i] = t.Body
+				}
+				result, err := promptkit.LintInherited(tmpl.Declared(), bodies, includes)
+				if err != nil {
+					return fmt.Errorf("linting %q: %w", ref, err)
+				}
+				for _, v := range result.Undeclared {
+					fmt.Printf("%s: undeclared variable %q\n", ref, v)
+				}
+				for _, v := range result.Unused {
+					fmt.Printf("%s: declared variable %q is never used\n", ref, v)
+				}
+				for _, name := range result.MissingTemplates {
+					fmt.Printf("%s: template %q is not defined\n", ref, name)
+				}
+				problems += len(result.Undeclared) + len(result.Unused) + len(result.MissingTemplates)
+			}
+
+			if problems > 0 {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("found %d problem(s)", problems)
+			}
+			fmt.Println("No problems found.")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&dirs, "dir", "d", []string{promptkit.DefaultTemplateDir}, "template directory (repeat to layer directories; later ones override earlier ones)")
+	return cmd
+}
+
+func checkOutputCmd() *cobra.Command {
+	var dirs []string
+
+	cmd := &cobra.Command{
+		Use:   "check-output <template> [response-file]",
+		Short: "Check a model response against a template's output_schema",
+		Long:  "Extract the JSON value from a model response, read from response-file or standard input, and check it against the template's output_schema.",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reg, err := loadRegistry(dirs)
+			if err != nil {
+				return fmt.Errorf("loading templates: %w", err)
+			}
+
+			tmpl, err := reg.Get(args[0])
+			if err != nil {
+				return err
+			}
+
+			var response []byte
+			if len(args) == 2 {
+				response, err = os.ReadFile(args[1])
+			} else {
+				response, err = io.ReadAll(cmd.InOrStdin())
+			}
+			if err != nil {
+				return fmt.Errorf("reading response: %w", err)
+			}
+
+			if _, err := promptkit.ValidateOutput(tmpl.Meta, string(response)); err != nil {
+				return err
+			}
+			fmt.Println("Response is valid.")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&dirs, "dir", "d", []string{promptkit.DefaultTemplateDir}, "template directory (repeat to layer directories; later ones override earlier ones)")
 	return cmd
 }
 
 func listCmd() *cobra.Command {
-	var dir string
+	var (
+		dirs     []string
+		includes bool
+	)
 
 	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List all available templates",
+		Long:  "List every template, including every version of versioned templates. The version a bare template name selects is marked (latest).",
 		RunE: func(_ *cobra.Command, _ []string) error {
-			reg := registry.New()
-			if err := reg.LoadDir(dir); err != nil {
+			reg, err := loadRegistry(dirs)
+			if err != nil {
 				return fmt.Errorf("loading templates: %w", err)
 			}
 
-			templates := reg.List()
+			if includes {
+				listIncludes(reg, len(dirs) > 1)
+				return nil
+			}
+
+			templates := allVersions(reg)
 			if len(templates) == 0 {
 				fmt.Println("No templates found.")
 				return nil
 			}
+			versioned := slices.ContainsFunc(templates, func(t *promptkit.Template) bool { return t.Version != "" })
 
 			for _, tmpl := range templates {
 				desc := tmpl.Meta.Description
 				if desc == "" {
 					desc = "(no description)"
 				}
-				fmt.Printf("%-20s %s\n", tmpl.Name, desc)
+				fmt.Printf("%-20s ", tmpl.Name)
+				if versioned {
+					version := tmpl.Version
+					if latest, _ := reg.Get(tmpl.Name); latest == tmpl && version != "" {
+						version += " (latest)"
+					}
+					fmt.Printf("%-20s ", version)
+				}
+				if len(dirs) > 1 {
+					fmt.Printf("%-20s ", "["+tmpl.Layer+"]")
+				}
+				fmt.Println(desc)
 			}
 			return nil
 		},
 	}
 
-	cmd.Flags().StringVarP(&dir, "dir", "d", config.DefaultTemplateDir, "template directory")
+	cmd.Flags().StringArrayVarP(&dirs, "dir", "d", []string{promptkit.DefaultTemplateDir}, "template directory (repeat to layer directories; later ones override earlier ones)")
+	cmd.Flags().BoolVar(&includes, "includes", false, "list includes instead of templates")
 	return cmd
 }
 
+// allVersions returns every loaded template sorted by name, with the
+// versions of each name newest first.
+func allVersions(reg *promptkit.Registry) []*promptkit.Template {
+	var names []string
+	for _, tmpl := range reg.List() {
+		if !slices.Contains(names, tmpl.Name) {
+			names = append(names, tmpl.Name)
+		}
+	}
+	sort.Strings(names)
+
+	var templates []*promptkit.Template
+	for _, name := range names {
+		templates = append(templates, reg.Versions(name)...)
+	}
+	return templates
+}
+
+// listIncludes prints every include with its scope, required variables and
+// description.
+func listIncludes(reg *promptkit.Registry, layered bool) {
+	incs := reg.ListIncludes()
+	if len(incs) == 0 {
+		fmt.Println("No includes found.")
+		return
+	}
+
+	for _, inc := range incs {
+		name := inc.Name
+		if inc.Scope != "." {
+			name = inc.Scope + ":" + inc.Name
+		}
+		desc := inc.Meta.Description
+		if desc == "" {
+			desc = "(no description)"
+		}
+		if req := inc.Meta.Required(); len(req) > 0 {
+			desc += " (requires " + strings.Join(req, ", ") + ")"
+		}
+		if layered {
+			fmt.Printf("%-20s %-20s %s\n", name, "["+inc.Layer+"]", desc)
+			continue
+		}
+		fmt.Printf("%-20s %s\n", name, desc)
+	}
+}
+
 func chainCmd() *cobra.Command {
 	var (
-		dir     string
+		dirs    []string
 		varFlag []string
+		strict  bool
+		tokFlag []string
+		dryRun  bool
+		model   string
+		workers int
+		prov    providerFlags
 	)
 
 	cmd := &cobra.Command{
 		Use:   "chain <chain.yaml>",
 		Short: "Execute a prompt chain",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(_ *cobra.Command, args []string) error {
-			def, err := chain.ParseFile(args[0])
+		Long: "Render each step of a chain, send it to the model and pass the response to later steps. With --dry-run, print what would be sent instead: each step's output is its rendered prompt.\n\n" +
+			"Each step is sent to the model named by --model, or else by the template's generation settings. The openai provider talks to any OpenAI-compatible server and reads OPENAI_API_KEY and OPENAI_BASE_URL; the anthropic provider reads ANTHROPIC_API_KEY and ANTHROPIC_BASE_URL, and needs --model unless every template names a claude model.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			def, err := promptkit.ParseChainFile(args[0])
 			if err != nil {
 				return err
 			}
 
-			reg := registry.New()
-			if err := reg.LoadDir(dir); err != nil {
+			reg, err := loadRegistry(dirs)
+			if err != nil {
 				return fmt.Errorf("loading templates: %w", err)
 			}
 
 			vars := parseVars(varFlag)
 
-			result, err := chain.Execute(def, reg, vars)
+			tokenizers, err := loadTokenizers(tokFlag)
+			if err != nil {
+				return err
+			}
+			opts := promptkit.ChainOptions{
+				Render:  promptkit.RenderOptions{Strict: strict, Tokenizers: tokenizers, Model: model},
+				DryRun:  dryRun,
+				Workers: workers,
+			}
+			if prov.stream && !cmd.Flags().Changed("workers") {
+				// Keep streamed responses from interleaving.
+				opts.Workers = 1
+			}
+			if !dryRun {
+				if opts.Provider, err = prov.provider(model); err != nil {
+					return err
+				}
+			}
+
+			result, err := promptkit.ExecuteChainContext(cmd.Context(), def, reg, vars, opts)
 			if err != nil {
 				return err
 			}
@@ -178,12 +475,77 @@ func chainCmd() *cobra.Command {
 		},
 	}
 
-	cmd.Flags().StringVarP(&dir, "dir", "d", config.DefaultTemplateDir, "template directory")
+	cmd.Flags().StringArrayVarP(&dirs, "dir", "d", []string{promptkit.DefaultTemplateDir}, "template directory (repeat to layer directories; later ones override earlier ones)")
 	cmd.Flags().StringArrayVar(&varFlag, "var", nil, "variable in key=value format")
+	cmd.Flags().BoolVar(&strict, "strict", false, "fail on missing keys and nil values")
+	cmd.Flags().StringArrayVar(&tokFlag, "tokenizer", nil, "tokenizer vocabulary in model=path format (.tiktoken or .model)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "render the steps without calling a model; each output is the rendered prompt")
+	cmd.Flags().StringVar(&model, "model", "", "model to send every step to, overriding the templates' generation settings")
+	cmd.Flags().IntVar(&workers, "workers", promptkit.DefaultChainWorkers, "maximum number of independent steps to run at once")
+	prov.register(cmd)
+	cmd.MarkFlagsMutuallyExclusive("dry-run", "record")
+	cmd.MarkFlagsMutuallyExclusive("dry-run", "replay")
 
 	return cmd
 }
 
+// providerFlags selects and configures the model provider.
+type providerFlags struct {
+	name    string
+	baseURL string
+	stream  bool
+	record  string
+	replay  string
+}
+
+func (f *providerFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.name, "provider", "openai", "model provider: openai or anthropic")
+	cmd.Flags().StringVar(&f.baseURL, "base-url", "", "API base URL of the provider, such as http://localhost:8000/v1")
+	cmd.Flags().BoolVar(&f.stream, "stream", false, "print responses to stderr as they arrive (anthropic provider)")
+	cmd.Flags().StringVar(&f.record, "record", "", "record every request and response to this cassette file")
+	cmd.Flags().StringVar(&f.replay, "replay", "", "serve responses from this cassette file instead of calling the provider")
+	cmd.MarkFlagsMutuallyExclusive("record", "replay")
+	cmd.MarkFlagsMutuallyExclusive("replay", "provider")
+}
+
+// provider returns the selected provider, configured to use model, wrapped to
+// record or replay a cassette if asked.
+func (f *providerFlags) provider(model string) (promptkit.Provider, error) {
+	if f.replay != "" {
+		return promptkit.NewReplayer(f.replay)
+	}
+	p, err := f.model(model)
+	if err != nil || f.record == "" {
+		return p, err
+	}
+	return promptkit.NewRecorder(p, f.record), nil
+}
+
+// model returns the provider that calls the model.
+func (f *providerFlags) model(model string) (promptkit.Provider, error) {
+	switch f.name {
+	case "openai":
+		return promptkit.NewOpenAIProvider(promptkit.OpenAIConfig{BaseURL: f.baseURL, Model: model}), nil
+	case "anthropic":
+		cfg := promptkit.AnthropicConfig{BaseURL: f.baseURL, Model: model, Stream: f.stream}
+		if f.stream {
+			cfg.OnText = func(text string) { fmt.Fprint(os.Stderr, text) }
+		}
+		return promptkit.NewAnthropicProvider(cfg), nil
+	}
+	return nil, fmt.Errorf("unknown provider %q", f.name)
+}
+
+// loadRegistry loads templates from dirs, layering later directories over
+// earlier ones.
+func loadRegistry(dirs []string) (*promptkit.Registry, error) {
+	layers := make([]promptkit.Layer, len(dirs))
+	for i, dir := range dirs {
+		layers[i] = promptkit.DirLayer(dir)
+	}
+	return promptkit.Layered(layers...)
+}
+
 func parseVars(flags []string) map[string]any {
 	vars := make(map[string]any, len(flags))
 	for _, f := range flags {
@@ -194,3 +556,27 @@ func parseVars(flags []string) map[string]any {
 	}
 	return vars
 }
+
+// loadTokenizers loads model=path tokenizer flags. A path without a model
+// applies to every model.
+func loadTokenizers(flags []string) (promptkit.TokenizerSet, error) {
+	set := make(promptkit.TokenizerSet, len(flags))
+	for _, f := range flags {
+		model, path, ok := strings.Cut(f, "=")
+		if !ok {
+			model, path = "", f
+		}
+		tok, err := promptkit.LoadTokenizer(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading tokenizer for %q: %w", model, err)
+		}
+		set[model] = tok
+	}
+	return set, nil
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
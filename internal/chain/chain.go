@@ -2,21 +2,52 @@
 package chain
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 
 	"gopkg.in/yaml.v3"
 
 	"github.com/devaloi/promptkit/internal/engine"
+	"github.com/devaloi/promptkit/internal/provider"
 	"github.com/devaloi/promptkit/internal/registry"
 	"github.com/devaloi/promptkit/internal/validator"
 )
 
+// ErrNoProvider is returned when a chain is executed without a provider
+// outside a dry run.
+var ErrNoProvider = errors.New("chain needs a model provider unless it is a dry run")
+
 // Step defines a single step in a prompt chain.
 type Step struct {
-	Template  string            `yaml:"template"`
+	// Name identifies the step in other steps' depends_on; it is optional.
+	Name string `yaml:"name"`
+
+	Template string `yaml:"template"`
+
+	// Version pins the template to a version constraint, such as "1.2.0" or
+	// "^1.2"; see registry.Registry.Get. It may also be given in Template as
+	// "summarize@^1.2".
+	Version string `yaml:"version"`
+
 	Vars      map[string]string `yaml:"vars"`
 	OutputVar string            `yaml:"output_var"`
+
+	// DependsOn lists the names or output_vars of steps that must finish
+	// before this one starts, in addition to the steps whose output_vars
+	// Vars reference.
+	DependsOn []string `yaml:"depends_on"`
+}
+
+// Ref returns the registry reference of the step's template, with the pinned
+// version if there is one.
+func (s Step) Ref() string {
+	if s.Version == "" {
+		return s.Template
+	}
+	return s.Template + "@" + s.Version
 }
 
 // Definition is a parsed chain YAML file.
@@ -25,10 +56,43 @@ type Definition struct {
 	Steps []Step `yaml:"steps"`
 }
 
+// Templates returns the references of the templates rendered by the chain's
+// steps, in step order and without duplicates.
+func (d Definition) Templates() []string {
+	var refs []string
+	for _, step := range d.Steps {
+		if ref := step.Ref(); !slices.Contains(refs, ref) {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
 // Result holds the outputs from executing a chain.
 type Result struct {
-	Final         string
+	Final string
+
+	// Intermediates maps each output_var to the output of the last step in
+	// the definition that sets it.
 	Intermediates map[string]string
+
+	// Steps records every step in definition order.
+	Steps []StepResult
+}
+
+// StepResult records what a single step sent to the model and received.
+type StepResult struct {
+	// Template is the reference of the template the step rendered, with its
+	// version if it has one.
+	Template string
+
+	Messages []engine.Message
+
+	// Output is the model's response, or the rendered prompt in a dry run.
+	Output string
+
+	// Response is the provider's full response; it is empty in a dry run.
+	Response provider.Response
 }
 
 // ParseFile reads and parses a chain definition from a YAML file.
@@ -49,62 +113,161 @@ func Parse(data []byte) (Definition, error) {
 	if len(def.Steps) == 0 {
 		return Definition{}, fmt.Errorf("chain %q has no steps", def.Name)
 	}
+	if _, err := newPlan(def); err != nil {
+		return Definition{}, fmt.Errorf("chain %q: %w", def.Name, err)
+	}
 	return def, nil
 }
 
-// Execute runs a chain definition against a registry, passing initial vars.
-// Each step renders a template and captures output into the variable namespace.
+// Options controls how a chain is executed.
+type Options struct {
+	// Render is applied to every template render, including the step var
+	// references. Render.Model selects the model requested from Provider.
+	Render engine.Options
+
+	// Provider completes each step's rendered messages. It is required
+	// unless DryRun is set, and must be safe for concurrent use unless
+	// Workers is 1.
+	Provider provider.Provider
+
+	// Workers limits how many independent steps run at once; DefaultWorkers
+	// if zero.
+	Workers int
+
+	// DryRun renders every step without calling a model, so each output_var
+	// holds the rendered prompt instead of a response.
+	DryRun bool
+}
+
+// Execute runs a chain definition against a registry as a dry run, passing
+// initial vars. Each step renders a template and captures the rendered
+// prompt into the variable namespace; use ExecuteContext to call a model.
 func Execute(def Definition, reg *registry.Registry, initialVars map[string]any) (Result, error) {
-	vars := make(map[string]any, len(initialVars))
-	for k, v := range initialVars {
-		vars[k] = v
+	return ExecuteContext(context.Background(), def, reg, initialVars, Options{DryRun: true})
+}
+
+// ExecuteWithOptions runs a chain like ExecuteContext without a deadline.
+func ExecuteWithOptions(def Definition, reg *registry.Registry, initialVars map[string]any, opts Options) (Result, error) {
+	return ExecuteContext(context.Background(), def, reg, initialVars, opts)
+}
+
+// ExecuteContext runs a chain definition against a registry, passing initial
+// vars. Each step renders a template, sends the rendered messages to
+// opts.Provider and captures the response into the variable namespace. The
+// context is passed to every provider call.
+//
+// A step starts once the steps it depends on have finished (see
+// Step.DependsOn), and independent steps run concurrently, up to
+// opts.Workers at a time. Each step sees the initial vars and the outputs of
+// the steps it depends on, directly or transitively, so the result does not
+// depend on timing. Final is the output of the last step in the definition.
+func ExecuteContext(ctx context.Context, def Definition, reg *registry.Registry, initialVars map[string]any, opts Options) (Result, error) {
+	if opts.Provider == nil && !opts.DryRun {
+		return Result{}, ErrNoProvider
+	}
+	if len(def.Steps) == 0 {
+		return Result{}, fmt.Errorf("chain %q has no steps", def.Name)
+	}
+	p, err := newPlan(def)
+	if err != nil {
+		return Result{}, err
 	}
 
-	intermediates := make(map[string]string, len(def.Steps))
-	var lastOutput string
+	steps := make([]StepResult, len(def.Steps))
+	err = p.run(ctx, opts.Workers, func(ctx context.Context, i int) error {
+		vars := make(map[string]any, len(initialVars)+len(p.ancestors[i]))
+		for k, v := range initialVars {
+			vars[k] = v
+		}
+		for _, j := range p.ancestors[i] {
+			if out := def.Steps[j].OutputVar; out != "" {
+				vars[out] = steps[j].Output
+			}
+		}
+		for v, j := range p.inputs[i] {
+			vars[v] = steps[j].Output
+		}
 
+		var err error
+		steps[i], err = executeStep(ctx, i, def.Steps[i], reg, vars, opts)
+		return err
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	intermediates := make(map[string]string, len(def.Steps))
 	for i, step := range def.Steps {
-		tmpl, err := reg.Get(step.Template)
-		if err != nil {
-			return Result{}, fmt.Errorf("step %d: %w", i+1, err)
+		if step.OutputVar != "" {
+			intermediates[step.OutputVar] = steps[i].Output
 		}
+	}
+	return Result{Final: steps[len(steps)-1].Output, Intermediates: intermediates, Steps: steps}, nil
+}
 
-		// Build step vars: resolve any template references from current var namespace.
-		stepVars := make(map[string]any, len(step.Vars))
-		for k, v := range step.Vars {
-			stepVars[k] = resolveVar(v, vars)
-		}
+// executeStep renders step i with vars and, unless opts.DryRun is set, sends
+// it to opts.Provider.
+func executeStep(ctx context.Context, i int, step Step, reg *registry.Registry, vars map[string]any, opts Options) (StepResult, error) {
+	if err := ctx.Err(); err != nil {
+		return StepResult{}, fmt.Errorf("step %d: %w", i+1, err)
+	}
 
-		// Validate required vars.
-		if len(tmpl.Meta.RequiredVars) > 0 {
-			if err := validator.Validate(tmpl.Meta.RequiredVars, stepVars); err != nil {
-				return Result{}, fmt.Errorf("step %d (%s): %w", i+1, step.Template, err)
-			}
-		}
+	tmpl, err := reg.Get(step.Ref())
+	if err != nil {
+		return StepResult{}, fmt.Errorf("step %d: %w", i+1, err)
+	}
+	ref := tmpl.Ref()
 
-		// Render the template.
-		result, err := engine.Render(tmpl.Content, stepVars, reg.Includes())
+	// Build step vars: resolve any template references from the var namespace.
+	stepVars := make(map[string]any, len(step.Vars))
+	for k, v := range step.Vars {
+		resolved, err := resolveVar(v, vars, opts.Render)
 		if err != nil {
-			return Result{}, fmt.Errorf("step %d (%s): rendering: %w", i+1, step.Template, err)
+			return StepResult{}, fmt.Errorf("step %d (%s): resolving var %q: %w", i+1, ref, k, err)
 		}
+		stepVars[k] = resolved
+	}
 
-		lastOutput = result.Output
+	// Validate vars against the template's required vars and schema.
+	if err := validator.ValidateMeta(tmpl.Meta, stepVars); err != nil {
+		return StepResult{}, fmt.Errorf("step %d (%s): %w", i+1, ref, err)
+	}
 
-		// Capture output into the variable namespace.
-		if step.OutputVar != "" {
-			vars[step.OutputVar] = result.Output
-			intermediates[step.OutputVar] = result.Output
-		}
+	// Render the template.
+	result, err := reg.RenderMessagesWithOptions(ref, stepVars, opts.Render)
+	if err != nil {
+		return StepResult{}, fmt.Errorf("step %d (%s): rendering: %w", i+1, ref, err)
 	}
 
-	return Result{Final: lastOutput, Intermediates: intermediates}, nil
+	stepResult := StepResult{Template: ref, Messages: result.Messages, Output: result.Output}
+	if opts.DryRun {
+		return stepResult, nil
+	}
+	generation := result.Meta.Generation
+	resp, err := opts.Provider.Complete(ctx, provider.Request{
+		Template:     ref,
+		Messages:     result.Messages,
+		Params:       result.Generation,
+		Generation:   &generation,
+		OutputSchema: result.Meta.OutputSchema,
+	})
+	if err != nil {
+		return StepResult{}, fmt.Errorf("step %d (%s): calling model: %w", i+1, ref, err)
+	}
+	stepResult.Output = resp.Content
+	stepResult.Response = resp
+	return stepResult, nil
 }
 
 // resolveVar resolves simple {{ .varname }} references in a string value.
-func resolveVar(val string, vars map[string]any) string {
-	result, err := engine.Render(val, vars, nil)
+// Outside strict mode a value that fails to render is used verbatim.
+func resolveVar(val string, vars map[string]any, opts engine.Options) (string, error) {
+	result, err := engine.RenderWithOptions(val, vars, nil, opts)
 	if err != nil {
-		return val
+		if opts.Strict {
+			return "", err
+		}
+		return val, nil
 	}
-	return result.Output
+	return result.Output, nil
 }
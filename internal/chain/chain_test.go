@@ -1,10 +1,18 @@
 package chain
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/devaloi/promptkit/internal/engine"
+	"github.com/devaloi/promptkit/internal/provider"
 	"github.com/devaloi/promptkit/internal/registry"
 )
 
@@ -126,3 +134,212 @@ func TestChain_VariablePassingBetweenSteps(t *testing.T) {
 		t.Error("expected final_out in intermediates")
 	}
 }
+
+func TestChain_StrictUnknownReference(t *testing.T) {
+	reg, _ := setupChainTest(t)
+	def := Definition{
+		Name: "typo-chain",
+		Steps: []Step{
+			{Template: "step_one", Vars: map[string]string{"input": "{{ .user_inptu }}"}, OutputVar: "out"},
+		},
+	}
+
+	result, err := Execute(def, reg, map[string]any{"user_input": "hello"})
+	if err != nil {
+		t.Fatalf("unexpected error in non-strict mode: %v", err)
+	}
+	if result.Final != "Processed: <no value>" {
+		t.Errorf("unexpected non-strict output: %q", result.Final)
+	}
+
+	_, err = ExecuteWithOptions(def, reg, map[string]any{"user_input": "hello"}, Options{Render: engine.Options{Strict: true}, DryRun: true})
+	if err == nil {
+		t.Fatal("expected error in strict mode")
+	}
+	if !strings.Contains(err.Error(), "user_inptu") {
+		t.Errorf("expected error to name the missing key, got %v", err)
+	}
+}
+
+func TestDefinition_Templates(t *testing.T) {
+	def, err := Parse([]byte(`name: review
+steps:
+  - template: draft
+  - template: critique
+  - template: draft
+`))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if got := strings.Join(def.Templates(), ","); got != "draft,critique" {
+		t.Errorf("unexpected templates: %s", got)
+	}
+}
+
+func TestChain_PinnedVersion(t *testing.T) {
+	dir := t.TempDir()
+	for _, v := range []string{"1.0.0", "1.4.0", "2.0.0"} {
+		writeFile(t, filepath.Join(dir, "greet-"+v+".tmpl"), `---
+name: greet
+version: `+v+`
+---
+v`+v+` {{ .who }}`)
+	}
+	reg := registry.New()
+	if err := reg.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	def, err := Parse([]byte(`name: pinned
+steps:
+  - template: greet
+    version: ^1.0
+    vars:
+      who: "{{ .name }}"
+    output_var: a
+  - template: greet@1.0.0
+    vars:
+      who: "{{ .name }}"
+    output_var: b
+  - template: greet
+    vars:
+      who: "{{ .name }}"
+`))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	result, err := Execute(def, reg, map[string]any{"name": "Ada"})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if got := result.Intermediates["a"]; got != "v1.4.0 Ada" {
+		t.Errorf("step 1 = %q, want the newest 1.x", got)
+	}
+	if got := result.Intermediates["b"]; got != "v1.0.0 Ada" {
+		t.Errorf("step 2 = %q, want the exact version", got)
+	}
+	if result.Final != "v2.0.0 Ada" {
+		t.Errorf("step 3 = %q, want the latest version", result.Final)
+	}
+	if got := strings.Join(def.Templates(), ","); got != "greet@^1.0,greet@1.0.0,greet" {
+		t.Errorf("unexpected templates: %s", got)
+	}
+
+	def.Steps[0].Version = "^3"
+	if _, err := Execute(def, reg, map[string]any{"name": "Ada"}); err == nil || !strings.Contains(err.Error(), "no version") {
+		t.Errorf("expected a no-match error, got %v", err)
+	}
+}
+
+func TestExecuteContext_Provider(t *testing.T) {
+	reg, chainPath := setupChainTest(t)
+	def, err := ParseFile(chainPath)
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+
+	var requests []provider.Request
+	echo := provider.Func(func(_ context.Context, req provider.Request) (provider.Response, error) {
+		requests = append(requests, req)
+		content := strings.ToUpper(req.Messages[len(req.Messages)-1].Content)
+		return provider.Response{Content: content, Model: req.Params.Model, StopReason: "stop"}, nil
+	})
+
+	opts := Options{Provider: echo, Render: engine.Options{Model: "test-model"}}
+	result, err := ExecuteContext(context.Background(), def, reg, map[string]any{"user_input": "hello"}, opts)
+	if err != nil {
+		t.Fatalf("ExecuteContext error: %v", err)
+	}
+
+	if result.Intermediates["step_one_out"] != "PROCESSED: HELLO" {
+		t.Errorf("expected the response in step_one_out, got %q", result.Intermediates["step_one_out"])
+	}
+	if result.Final != "FINAL: PROCESSED: HELLO" {
+		t.Errorf("unexpected final output: %q", result.Final)
+	}
+	if len(requests) != 2 || requests[0].Template != "step_one" || requests[0].Params.Model != "test-model" || requests[0].Generation == nil {
+		t.Fatalf("unexpected requests: %+v", requests)
+	}
+	if got := requests[1].Messages; len(got) != 1 || got[0].Role != engine.RoleUser || got[0].Content != "Final: PROCESSED: HELLO" {
+		t.Errorf("unexpected step 2 messages: %+v", got)
+	}
+	if len(result.Steps) != 2 || result.Steps[1].Response.StopReason != "stop" || result.Steps[1].Messages[0].Content != "Final: PROCESSED: HELLO" {
+		t.Errorf("unexpected steps: %+v", result.Steps)
+	}
+}
+
+func TestExecuteContext_Errors(t *testing.T) {
+	reg, chainPath := setupChainTest(t)
+	def, err := ParseFile(chainPath)
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+	vars := map[string]any{"user_input": "hello"}
+
+	if _, err := ExecuteWithOptions(def, reg, vars, Options{}); !errors.Is(err, ErrNoProvider) {
+		t.Errorf("expected ErrNoProvider, got %v", err)
+	}
+
+	failing := provider.Func(func(context.Context, provider.Request) (provider.Response, error) {
+		return provider.Response{}, errors.New("rate limited")
+	})
+	_, err = ExecuteWithOptions(def, reg, vars, Options{Provider: failing})
+	if err == nil || !strings.Contains(err.Error(), "step 1 (step_one): calling model: rate limited") {
+		t.Errorf("unexpected provider error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelling := provider.Func(func(context.Context, provider.Request) (provider.Response, error) {
+		cancel()
+		return provider.Response{Content: "ok"}, nil
+	})
+	if _, err := ExecuteContext(ctx, def, reg, vars, Options{Provider: cancelling}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestExecuteContext_OpenAI(t *testing.T) {
+	reg, chainPath := setupChainTest(t)
+	def, err := ParseFile(chainPath)
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+
+	// An OpenAI-compatible server that answers every prompt with its length.
+	var models []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Model    string `json:"model"`
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		models = append(models, body.Model)
+		reply := strings.Repeat("x", len(body.Messages[0].Content))
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]any{"role": "assistant", "content": reply}}},
+		})
+	}))
+	defer server.Close()
+
+	opts := Options{
+		Provider: provider.NewOpenAI(provider.OpenAIConfig{BaseURL: server.URL}),
+		Render:   engine.Options{Model: "local-model"},
+	}
+	result, err := ExecuteContext(context.Background(), def, reg, map[string]any{"user_input": "hello"}, opts)
+	if err != nil {
+		t.Fatalf("ExecuteContext error: %v", err)
+	}
+
+	// "Processed: hello" has 16 characters and "Final: " plus 16 has 23.
+	if result.Intermediates["step_one_out"] != strings.Repeat("x", 16) || result.Final != strings.Repeat("x", 23) {
+		t.Errorf("unexpected outputs: %+v", result)
+	}
+	if strings.Join(models, ",") != "local-model,local-model" {
+		t.Errorf("unexpected models: %v", models)
+	}
+}
@@ -0,0 +1,255 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/devaloi/promptkit/internal/validator"
+)
+
+// DefaultWorkers is the number of steps run at once when Options.Workers is
+// not set.
+const DefaultWorkers = 4
+
+// plan holds the dependencies between the steps of a chain.
+type plan struct {
+	// deps lists the steps each step depends on directly, and ancestors
+	// every step it depends on transitively, by index and sorted.
+	deps      [][]int
+	ancestors [][]int
+
+	// inputs maps the output_vars each step's vars reference to the step
+	// whose output they read.
+	inputs []map[string]int
+}
+
+// newPlan builds the dependency graph of def. A step depends on the steps
+// named in its depends_on and on the step whose output_var each of its vars
+// references. When several steps set the same output_var, a reference reads
+// the nearest earlier one, so a step can refine the output of an earlier step
+// into the same var; see writer. Unknown
+// dependencies and dependency cycles are errors.
+func newPlan(def Definition) (plan, error) {
+	n := len(def.Steps)
+	names := make(map[string]int, n)
+	outputs := make(map[string][]int, n)
+	for i, step := range def.Steps {
+		if step.Name != "" {
+			if j, ok := names[step.Name]; ok {
+				return plan{}, fmt.Errorf("steps %d and %d are both named %q", j+1, i+1, step.Name)
+			}
+			names[step.Name] = i
+		}
+		if step.OutputVar != "" {
+			outputs[step.OutputVar] = append(outputs[step.OutputVar], i)
+		}
+	}
+
+	p := plan{deps: make([][]int, n), ancestors: make([][]int, n), inputs: make([]map[string]int, n)}
+	for i, step := range def.Steps {
+		add := func(j int) {
+			if !slices.Contains(p.deps[i], j) {
+				p.deps[i] = append(p.deps[i], j)
+			}
+		}
+		for _, dep := range step.DependsOn {
+			j, ok := names[dep]
+			if !ok {
+				j, ok = writer(outputs[dep], i)
+			}
+			if !ok && slices.Contains(outputs[dep], i) {
+				// Depending on its own output_var alone is a cycle.
+				j, ok = i, true
+			}
+			if !ok {
+				return plan{}, fmt.Errorf("step %s depends on %q, which names no step or output_var", stepLabel(def, i), dep)
+			}
+			add(j)
+		}
+		for _, value := range step.Vars {
+			// Values that do not parse are used verbatim, so reference nothing.
+			usage, _ := validator.Analyze(value, nil)
+			for _, v := range usage.Vars {
+				if j, ok := writer(outputs[v], i); ok {
+					if p.inputs[i] == nil {
+						p.inputs[i] = make(map[string]int)
+					}
+					p.inputs[i][v] = j
+					add(j)
+				}
+			}
+		}
+		slices.Sort(p.deps[i])
+	}
+
+	if cycle := p.cycle(); cycle != nil {
+		labels := make([]string, len(cycle))
+		for k, i := range cycle {
+			labels[k] = "step " + stepLabel(def, i)
+		}
+		return plan{}, fmt.Errorf("chain has a dependency cycle: %s", strings.Join(labels, " -> "))
+	}
+
+	for i := range def.Steps {
+		p.ancestors[i] = p.collect(i, make([]bool, n), nil)
+		slices.Sort(p.ancestors[i])
+	}
+	return p, nil
+}
+
+// writer returns the step among writers, sorted by index, whose output step
+// i reads: the nearest one before i or, unless i is itself a writer and so
+// reads the initial var, the first one after it.
+func writer(writers []int, i int) (int, bool) {
+	k, self := slices.BinarySearch(writers, i)
+	switch {
+	case k > 0:
+		return writers[k-1], true
+	case !self && k < len(writers):
+		return writers[k], true
+	}
+	return 0, false
+}
+
+// cycle returns a dependency cycle as the steps along it, starting and
+// ending with the same step, or nil if there is none.
+func (p plan) cycle() []int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(p.deps))
+	var path []int
+
+	var visit func(i int) []int
+	visit = func(i int) []int {
+		state[i] = visiting
+		path = append(path, i)
+		for _, j := range p.deps[i] {
+			switch state[j] {
+			case visiting:
+				start := slices.Index(path, j)
+				return append(slices.Clone(path[start:]), j)
+			case unvisited:
+				if cycle := visit(j); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+
+	for i := range p.deps {
+		if state[i] == unvisited {
+			if cycle := visit(i); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// collect appends every step i depends on transitively to found.
+func (p plan) collect(i int, seen []bool, found []int) []int {
+	for _, j := range p.deps[i] {
+		if !seen[j] {
+			seen[j] = true
+			found = p.collect(j, seen, append(found, j))
+		}
+	}
+	return found
+}
+
+// stepLabel names step i in errors: its number with its name, output_var or
+// template.
+func stepLabel(def Definition, i int) string {
+	step := def.Steps[i]
+	label := step.Name
+	if label == "" {
+		label = step.OutputVar
+	}
+	if label == "" {
+		label = step.Template
+	}
+	return fmt.Sprintf("%d (%s)", i+1, label)
+}
+
+// run calls fn for every step once the steps it depends on have finished,
+// with at most workers calls at a time. Ready steps start in step order.
+// After a failure no further steps start, the context passed to running
+// steps is cancelled, and run returns the error of the first failed step in
+// step order, preferring errors other than that cancellation.
+func (p plan) run(ctx context.Context, workers int, fn func(ctx context.Context, i int) error) error {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	n := len(p.deps)
+	waiting := make([]int, n)
+	dependents := make([][]int, n)
+	var ready []int
+	for i, deps := range p.deps {
+		waiting[i] = len(deps)
+		for _, j := range deps {
+			dependents[j] = append(dependents[j], i)
+		}
+		if len(deps) == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	type outcome struct {
+		step int
+		err  error
+	}
+	done := make(chan outcome)
+	errs := make([]error, n)
+	running := 0
+	failed := false
+	for {
+		for !failed && running < workers && len(ready) > 0 {
+			i := ready[0]
+			ready = ready[1:]
+			running++
+			go func() { done <- outcome{i, fn(runCtx, i)} }()
+		}
+		if running == 0 {
+			break
+		}
+
+		o := <-done
+		running--
+		if o.err != nil {
+			errs[o.step] = o.err
+			failed = true
+			cancel()
+			continue
+		}
+		for _, d := range dependents[o.step] {
+			if waiting[d]--; waiting[d] == 0 {
+				ready = append(ready, d)
+				slices.Sort(ready)
+			}
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil && (ctx.Err() != nil || !errors.Is(err, context.Canceled)) {
+			return err
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
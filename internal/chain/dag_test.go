@@ -0,0 +1,289 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/devaloi/promptkit/internal/provider"
+	"github.com/devaloi/promptkit/internal/registry"
+)
+
+func TestNewPlan(t *testing.T) {
+	def, err := Parse([]byte(`name: review
+steps:
+  - name: entities
+    template: extract
+    vars:
+      text: "{{ .document }}"
+    output_var: entities
+  - template: summarize
+    vars:
+      document: "{{ .document }}"
+    output_var: summary
+  - template: report
+    vars:
+      body: "{{ .summary }} {{ range .entities }}{{ . }}{{ end }}"
+    output_var: report
+  - template: notify
+    depends_on: [entities, report]
+`))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	p, err := newPlan(def)
+	if err != nil {
+		t.Fatalf("newPlan error: %v", err)
+	}
+	if got := fmt.Sprint(p.deps); got != "[[] [] [0 1] [0 2]]" {
+		t.Errorf("unexpected deps: %s", got)
+	}
+	if got := fmt.Sprint(p.ancestors); got != "[[] [] [0 1] [0 1 2]]" {
+		t.Errorf("unexpected ancestors: %s", got)
+	}
+}
+
+func TestNewPlan_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps string
+		want  string
+	}{
+		{"unknown", `
+  - template: a
+    depends_on: [missing]`, `step 1 (a) depends on "missing", which names no step or output_var`},
+		{"own output", `
+  - template: a
+    depends_on: [out]
+    output_var: out`, "dependency cycle: step 1 (out) -> step 1 (out)"},
+		{"duplicate name", `
+  - name: x
+    template: a
+  - name: x
+    template: b`, `steps 1 and 2 are both named "x"`},
+		{"cycle", `
+  - template: a
+    output_var: a
+  - template: b
+    vars:
+      in: "{{ .a }}{{ .c }}"
+    output_var: b
+  - template: c
+    vars:
+      in: "{{ .b }}"
+    output_var: c`, "dependency cycle: step 2 (b) -> step 3 (c) -> step 2 (b)"},
+		{"self", `
+  - name: loop
+    template: a
+    depends_on: [loop]`, "dependency cycle: step 1 (loop) -> step 1 (loop)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte("name: bad\nsteps:" + tt.steps))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// A step reading its own output_var reads the initial var.
+	if _, err := Parse([]byte("steps:\n  - template: a\n    vars:\n      in: \"{{ .out }}\"\n    output_var: out\n")); err != nil {
+		t.Errorf("unexpected error for a self reference: %v", err)
+	}
+}
+
+func TestExecuteContext_SameOutputVar(t *testing.T) {
+	reg := setupDAGTest(t)
+	def, err := Parse([]byte(`name: draft-refine
+steps:
+  - template: a
+    vars:
+      in: "{{ .text }}"
+    output_var: text
+  - template: b
+    vars:
+      in: "{{ .text }}"
+    output_var: text
+  - template: c
+    vars:
+      in: "{{ .text }}"
+`))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	p, err := newPlan(def)
+	if err != nil {
+		t.Fatalf("newPlan error: %v", err)
+	}
+	if got := fmt.Sprint(p.deps); got != "[[] [0] [1]]" {
+		t.Errorf("unexpected deps: %s", got)
+	}
+
+	result, err := ExecuteContext(context.Background(), def, reg, map[string]any{"text": "notes"}, Options{Provider: provider.Func(echo)})
+	if err != nil {
+		t.Fatalf("ExecuteContext error: %v", err)
+	}
+	if want := "[c:[b:[a:notes]]]"; result.Final != want {
+		t.Errorf("Final = %q, want %q", result.Final, want)
+	}
+	if got := result.Intermediates["text"]; got != "[b:[a:notes]]" {
+		t.Errorf("Intermediates[text] = %q", got)
+	}
+}
+
+// setupDAGTest loads templates that echo their input var.
+func setupDAGTest(t *testing.T) *registry.Registry {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		writeFile(t, filepath.Join(dir, name+".tmpl"), name+":{{ .in }}")
+	}
+	reg := registry.New()
+	if err := reg.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return reg
+}
+
+// diamond is a chain where b and c depend on a, and d on b and c.
+var diamond = Definition{Steps: []Step{
+	{Template: "a", Vars: map[string]string{"in": "{{ .input }}"}, OutputVar: "a"},
+	{Template: "b", Vars: map[string]string{"in": "{{ .a }}"}, OutputVar: "b"},
+	{Template: "c", Vars: map[string]string{"in": "{{ .a }}"}, OutputVar: "c"},
+	{Template: "d", Vars: map[string]string{"in": "{{ .b }}+{{ .c }}"}, OutputVar: "d"},
+}}
+
+// echo replies with the last message wrapped in brackets.
+func echo(_ context.Context, req provider.Request) (provider.Response, error) {
+	return provider.Response{Content: "[" + req.Messages[len(req.Messages)-1].Content + "]"}, nil
+}
+
+func TestExecuteContext_Parallel(t *testing.T) {
+	reg := setupDAGTest(t)
+
+	// b and c only return once both have started, so the chain can only
+	// finish if they run concurrently.
+	var started sync.WaitGroup
+	started.Add(2)
+	barrier := make(chan struct{})
+	go func() { started.Wait(); close(barrier) }()
+
+	model := provider.Func(func(ctx context.Context, req provider.Request) (provider.Response, error) {
+		if req.Template == "b" || req.Template == "c" {
+			started.Done()
+			select {
+			case <-barrier:
+			case <-time.After(5 * time.Second):
+				return provider.Response{}, errors.New("steps b and c did not run concurrently")
+			}
+		}
+		return echo(ctx, req)
+	})
+
+	result, err := ExecuteWithOptions(diamond, reg, map[string]any{"input": "x"}, Options{Provider: model, Workers: 2})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if result.Final != "[d:[b:[a:x]]+[c:[a:x]]]" {
+		t.Errorf("unexpected final output: %q", result.Final)
+	}
+	var templates []string
+	for _, step := range result.Steps {
+		templates = append(templates, step.Template)
+	}
+	if strings.Join(templates, ",") != "a,b,c,d" {
+		t.Errorf("steps are not in definition order: %v", templates)
+	}
+	if result.Intermediates["c"] != "[c:[a:x]]" {
+		t.Errorf("unexpected intermediates: %v", result.Intermediates)
+	}
+}
+
+func TestExecuteContext_Workers(t *testing.T) {
+	reg := setupDAGTest(t)
+	independent := Definition{Steps: []Step{
+		{Template: "a", Vars: map[string]string{"in": "1"}, OutputVar: "a"},
+		{Template: "b", Vars: map[string]string{"in": "2"}, OutputVar: "b"},
+		{Template: "c", Vars: map[string]string{"in": "3"}, OutputVar: "c"},
+		{Template: "d", Vars: map[string]string{"in": "{{ .a }}"}},
+	}}
+
+	for _, workers := range []int{1, 2} {
+		var inFlight, peak atomic.Int32
+		model := provider.Func(func(ctx context.Context, req provider.Request) (provider.Response, error) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			return echo(ctx, req)
+		})
+
+		result, err := ExecuteWithOptions(independent, reg, nil, Options{Provider: model, Workers: workers})
+		if err != nil {
+			t.Fatalf("workers %d: Execute error: %v", workers, err)
+		}
+		if p := peak.Load(); p > int32(workers) {
+			t.Errorf("workers %d: %d steps ran at once", workers, p)
+		}
+		if result.Final != "[d:[a:1]]" {
+			t.Errorf("workers %d: unexpected final output %q", workers, result.Final)
+		}
+	}
+}
+
+func TestExecuteContext_DryRunIsolation(t *testing.T) {
+	reg := setupDAGTest(t)
+	def := Definition{Steps: []Step{
+		{Template: "a", Vars: map[string]string{"in": "first"}, OutputVar: "a"},
+		{Template: "b", Vars: map[string]string{"in": "{{ .a }}"}, OutputVar: "b"},
+		{Template: "c", Vars: map[string]string{"in": "{{ .seen }}"}, DependsOn: []string{"a"}},
+	}}
+
+	// c depends on a without reading it, and does not see b's output even
+	// if b has already run.
+	result, err := ExecuteWithOptions(def, reg, map[string]any{"seen": "initial"}, Options{DryRun: true, Workers: 1})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if result.Final != "c:initial" || result.Intermediates["b"] != "b:a:first" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestExecuteContext_Failure(t *testing.T) {
+	reg := setupDAGTest(t)
+
+	var dCalled atomic.Bool
+	model := provider.Func(func(ctx context.Context, req provider.Request) (provider.Response, error) {
+		switch req.Template {
+		case "b":
+			return provider.Response{}, errors.New("rate limited")
+		case "c":
+			// c waits until b's failure cancels it.
+			<-ctx.Done()
+			return provider.Response{}, ctx.Err()
+		case "d":
+			dCalled.Store(true)
+		}
+		return echo(ctx, req)
+	})
+
+	_, err := ExecuteWithOptions(diamond, reg, map[string]any{"input": "x"}, Options{Provider: model})
+	if err == nil || err.Error() != "step 2 (b): calling model: rate limited" {
+		t.Errorf("expected step 2's error, got %v", err)
+	}
+	if dCalled.Load() {
+		t.Error("a step ran after its dependency failed")
+	}
+}
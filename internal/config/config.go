@@ -8,3 +8,38 @@ const (
 	// IncludesDir is the subdirectory for reusable template blocks.
 	IncludesDir = "includes"
 )
+
+// Environment variables and defaults for the built-in model providers.
+const (
+	// EnvOpenAIKey holds the API key sent to OpenAI-compatible servers.
+	EnvOpenAIKey = "OPENAI_API_KEY"
+
+	// EnvOpenAIBaseURL overrides DefaultOpenAIBaseURL, for example to use a
+	// local vLLM, llama.cpp or Ollama server.
+	EnvOpenAIBaseURL = "OPENAI_BASE_URL"
+
+	// DefaultOpenAIBaseURL is the OpenAI API root, including the version.
+	DefaultOpenAIBaseURL = "https://api.openai.com/v1"
+)
+
+// Environment variables and defaults for the Anthropic provider.
+const (
+	// EnvAnthropicKey holds the API key sent to the Anthropic API.
+	EnvAnthropicKey = "ANTHROPIC_API_KEY"
+
+	// EnvAnthropicBaseURL overrides DefaultAnthropicBaseURL.
+	EnvAnthropicBaseURL = "ANTHROPIC_BASE_URL"
+
+	// DefaultAnthropicBaseURL is the Anthropic API root, without the version.
+	DefaultAnthropicBaseURL = "https://api.anthropic.com"
+
+	// AnthropicVersion is the Messages API version requested.
+	AnthropicVersion = "2023-06-01"
+
+	// DefaultAnthropicMaxTokens is sent as max_tokens, which the Messages API
+	// requires, when neither the template nor the provider sets it.
+	DefaultAnthropicMaxTokens = 1024
+
+	// AnthropicModelPrefix starts the name of every Anthropic model.
+	AnthropicModelPrefix = "claude"
+)
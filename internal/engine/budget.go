@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"text/template"
+
+	"github.com/devaloi/promptkit/internal/tokenizer"
+)
+
+// BudgetError is returned when a rendered prompt exceeds the template's
+// token_budget even after every fit_budget section has been trimmed away.
+type BudgetError struct {
+	Tokens int
+	Budget int
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("rendered prompt is %d tokens, exceeding token_budget of %d", e.Tokens, e.Budget)
+}
+
+// section is a fit_budget section seen during the last execution.
+type section struct {
+	name     string
+	priority int
+	tokens   int
+}
+
+// budget trims fit_budget sections until a rendered prompt fits within limit
+// tokens. Sections with the lowest priority are trimmed first.
+type budget struct {
+	tok      tokenizer.Tokenizer
+	limit    int
+	sections map[string]*section
+	caps     map[string]int
+}
+
+func newBudget(tok tokenizer.Tokenizer, limit int) *budget {
+	return &budget{
+		tok:      tok,
+		limit:    limit,
+		sections: make(map[string]*section),
+		caps:     make(map[string]int),
+	}
+}
+
+// funcs returns the fit_budget helper bound to b.
+func (b *budget) funcs() template.FuncMap {
+	return template.FuncMap{"fit_budget": b.fit}
+}
+
+// fit records a section and returns text trimmed to the section's current cap.
+func (b *budget) fit(name string, priority int, text string) string {
+	tokens := b.tok.Count(text)
+	if s, ok := b.sections[name]; ok {
+		s.priority = priority
+		s.tokens = max(s.tokens, tokens)
+	} else {
+		b.sections[name] = &section{name: name, priority: priority, tokens: tokens}
+	}
+
+	if limit, ok := b.caps[name]; ok {
+		return truncateTokens(b.tok, limit, text)
+	}
+	return text
+}
+
+// fits reports whether a prompt of tokens fits the budget. A zero limit
+// disables the budget.
+func (b *budget) fits(tokens int) bool {
+	return b.limit <= 0 || tokens <= b.limit
+}
+
+// shrink lowers the cap of the lowest-priority section that still has text by
+// over tokens. It returns false when there is nothing left to trim.
+func (b *budget) shrink(over int) bool {
+	candidates := make([]*section, 0, len(b.sections))
+	for _, s := range b.sections {
+		if b.size(s) > 0 {
+			candidates = append(candidates, s)
+		}
+	}
+	if len(candidates) == 0 {
+		return false
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].priority != candidates[j].priority {
+			return candidates[i].priority < candidates[j].priority
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	s := candidates[0]
+	b.caps[s.name] = max(0, b.size(s)-over)
+	b.sections = make(map[string]*section)
+	return true
+}
+
+// size returns the number of tokens section s currently contributes.
+func (b *budget) size(s *section) int {
+	if limit, ok := b.caps[s.name]; ok {
+		return min(limit, s.tokens)
+	}
+	return s.tokens
+}
@@ -0,0 +1,254 @@
+package engine
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/devaloi/promptkit/internal/frontmatter"
+)
+
+// Compiled is a template parsed together with its includes, ready to be
+// executed any number of times. It is safe for concurrent use; each
+// execution works on its own clone of the parsed templates.
+type Compiled struct {
+	Meta frontmatter.Metadata
+
+	body      string
+	overrides []overlay
+	includes  []Include
+	params    map[string]map[string]frontmatter.VarSpec
+	tmpl      *template.Template
+
+	// sources maps the names of the parsed templates to the files they came
+	// from, for error locations.
+	sources map[string]source
+
+	// The strict variant rewrites the parse tree, so it is compiled
+	// separately on first use.
+	strictOnce sync.Once
+	strict     *template.Template
+	strictErr  error
+}
+
+// Compile parses frontmatter from content and parses the template body with
+// the provided include templates.
+func Compile(content string, includes map[string]string) (*Compiled, error) {
+	return compileNamed("", content, sortedIncludes(includes))
+}
+
+// compileNamed compiles content like Compile. Errors are reported against
+// name, or the template's frontmatter name if name is empty.
+func compileNamed(name, content string, includes []Include) (*Compiled, error) {
+	meta, body, offset := split(content)
+	if name == "" {
+		name = cmp.Or(meta.Name, "main")
+	}
+	return compile(meta, body, source{name: name, offset: offset}, nil, includes)
+}
+
+func compile(meta frontmatter.Metadata, body string, main source, overrides []overlay, includes []Include) (*Compiled, error) {
+	sources := map[string]source{"main": main}
+	for _, inc := range includes {
+		_, _, offset := split(inc.Content)
+		sources[inc.Name] = source{name: inc.Name, offset: offset}
+	}
+	for _, o := range overrides {
+		sources[o.name] = o.src
+	}
+
+	tmpl, err := parseTemplate(body, overrides, includes, sources, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Compiled{
+		Meta:      meta,
+		body:      body,
+		overrides: overrides,
+		includes:  includes,
+		params:    includeParams(includes),
+		tmpl:      tmpl,
+		sources:   sources,
+	}, nil
+}
+
+// source is the file a parsed template came from: the name errors report
+// and the number of frontmatter lines stripped from its body.
+type source struct {
+	name   string
+	offset int
+}
+
+// split separates content into frontmatter metadata and the template body,
+// and returns the number of lines that precede the body.
+func split(content string) (frontmatter.Metadata, string, int) {
+	parsed, err := frontmatter.Parse(content)
+
+	// If no frontmatter was found, render the entire content as a template.
+	if err != nil {
+		return frontmatter.Metadata{}, content, 0
+	}
+	return parsed.Meta, parsed.Body, parsed.Offset
+}
+
+// Execute renders the compiled template with vars. Role block markers are
+// removed from the output.
+func (c *Compiled) Execute(vars map[string]any, opts Options) (RenderResult, error) {
+	r, err := c.execute(vars, opts)
+	if err != nil {
+		return RenderResult{}, err
+	}
+	return RenderResult{
+		Output:     r.markers.strip(r.raw),
+		Meta:       c.Meta,
+		Generation: c.Meta.GenerationFor(opts.Model),
+		Tokens:     r.tokens,
+	}, nil
+}
+
+// ExecuteMessages renders the compiled template like Execute and splits the
+// output into role-tagged chat messages.
+func (c *Compiled) ExecuteMessages(vars map[string]any, opts Options) (RenderResult, error) {
+	r, err := c.execute(vars, opts)
+	if err != nil {
+		return RenderResult{}, err
+	}
+	messages, err := r.markers.split(r.raw)
+	if err != nil {
+		return RenderResult{}, err
+	}
+	return RenderResult{
+		Output:     r.markers.strip(r.raw),
+		Meta:       c.Meta,
+		Generation: c.Meta.GenerationFor(opts.Model),
+		Tokens:     r.tokens,
+		Messages:   messages,
+	}, nil
+}
+
+// model returns the model the template is rendered for: opts.Model, or the
+// template's own model.
+func (c *Compiled) model(opts Options) string {
+	if opts.Model != "" {
+		return opts.Model
+	}
+	return c.Meta.Model()
+}
+
+// rendered is the raw output of an execution, still containing role markers.
+type rendered struct {
+	raw     string
+	tokens  int
+	markers roleMarkers
+}
+
+func (c *Compiled) execute(vars map[string]any, opts Options) (rendered, error) {
+	base := c.tmpl
+	if opts.Strict {
+		c.strictOnce.Do(func() {
+			c.strict, c.strictErr = parseTemplate(c.body, c.overrides, c.includes, c.sources, true)
+		})
+		if c.strictErr != nil {
+			return rendered{}, c.strictErr
+		}
+		base = c.strict
+	}
+
+	tmpl, err := base.Clone()
+	if err != nil {
+		return rendered{}, fmt.Errorf("cloning template: %w", err)
+	}
+
+	vars = withDefaults(c.Meta, vars)
+
+	tok := opts.Tokenizers.For(c.model(opts))
+	b := newBudget(tok, c.Meta.TokenBudget)
+	markers := newRoleMarkers()
+	tmpl.Funcs(tokenFuncs(tok)).Funcs(b.funcs()).Funcs(markers.funcs()).Funcs(template.FuncMap{
+		"include":       c.include(tmpl),
+		"output_schema": c.outputSchema,
+	})
+
+	// Re-execute, trimming fit_budget sections, until the output fits.
+	for {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, vars); err != nil {
+			return rendered{}, fmt.Errorf("executing template: %w", newRenderError(err, c.sources))
+		}
+
+		raw := buf.String()
+		tokens := tok.Count(markers.strip(raw))
+		if b.fits(tokens) {
+			return rendered{raw: raw, tokens: tokens, markers: markers}, nil
+		}
+		if !b.shrink(tokens - b.limit) {
+			return rendered{}, &BudgetError{Tokens: tokens, Budget: b.limit}
+		}
+	}
+}
+
+// parseTemplate parses body as the "main" template alongside includes, then
+// parses each overlay so that its blocks replace those defined earlier.
+// Includes are parsed in order, so a template defined by several includes
+// takes the definition of the last. In strict mode missing keys are errors
+// and every output action is checked for nil. Parse errors are located in the
+// files named by sources.
+func parseTemplate(body string, overrides []overlay, includes []Include, sources map[string]source, strict bool) (*template.Template, error) {
+	tmpl := template.New("main").Funcs(FuncMap())
+	if strict {
+		tmpl = tmpl.Option("missingkey=error").Funcs(template.FuncMap{strictFuncName: strictValue})
+	}
+
+	// Register include templates without their frontmatter.
+	for _, inc := range includes {
+		_, incBody, _ := split(inc.Content)
+		if _, err := tmpl.New(inc.Name).Parse(incBody); err != nil {
+			return nil, fmt.Errorf("parsing include %q: %w", inc.Name, locate(err, sources))
+		}
+	}
+
+	if _, err := tmpl.Parse(body); err != nil {
+		return nil, fmt.Errorf("parsing template: %w", locate(err, sources))
+	}
+
+	for _, o := range overrides {
+		if _, err := tmpl.New(o.name).Parse(o.body); err != nil {
+			return nil, fmt.Errorf("parsing template %q: %w", o.src.name, locate(err, sources))
+		}
+	}
+
+	if strict {
+		instrumentStrict(tmpl)
+	}
+	return tmpl, nil
+}
+
+// locate rewrites the "template: name:line:" location that starts a
+// text/template parse error to the file the template came from. Errors
+// without a known location are returned unchanged.
+func locate(err error, sources map[string]source) error {
+	msg := err.Error()
+	internal := ""
+	for n := range sources {
+		if strings.HasPrefix(msg, "template: "+n+":") && len(n) > len(internal) {
+			internal = n
+		}
+	}
+	if internal == "" {
+		return err
+	}
+
+	rest := msg[len("template: "+internal+":"):]
+	digits, rest, ok := strings.Cut(rest, ":")
+	line, convErr := strconv.Atoi(digits)
+	if !ok || convErr != nil {
+		return err
+	}
+	src := sources[internal]
+	return fmt.Errorf("template: %s:%d:%s", src.name, line+src.offset, rest)
+}
@@ -1,50 +1,93 @@
 package engine
 
 import (
-	"bytes"
-	"fmt"
-	"text/template"
-
 	"github.com/devaloi/promptkit/internal/frontmatter"
+	"github.com/devaloi/promptkit/internal/tokenizer"
 )
 
 // RenderResult holds the output of rendering a template.
 type RenderResult struct {
 	Output string
 	Meta   frontmatter.Metadata
+
+	// Generation holds the template's generation settings resolved for the
+	// model being rendered for; see Options.Model.
+	Generation frontmatter.Generation
+
+	// Tokens is the token count of Output, measured with the tokenizer
+	// selected for the template.
+	Tokens int
+
+	// Messages holds the role-tagged chat messages; it is only populated by
+	// RenderMessages.
+	Messages []Message
+}
+
+// Options controls how a template is rendered. The zero value renders with
+// text/template's defaults.
+type Options struct {
+	// Strict fails rendering on missing map keys and on output actions that
+	// evaluate to nil, instead of printing "<no value>".
+	Strict bool
+
+	// Tokenizers selects the tokenizer used by token helpers, matched against
+	// the model being rendered for. Templates without a match use the ~4
+	// bytes per token heuristic.
+	Tokenizers tokenizer.Set
+
+	// Model is the model the prompt will be sent to. It selects the
+	// tokenizer and the generation overrides in RenderResult.Generation.
+	// Empty means the template's generation.model, or its model_hint.
+	Model string
 }
 
 // Render parses frontmatter from content, then renders the template body with
-// the provided variables and optional include templates.
+// the provided variables and optional include templates. Role block markers
+// ({{ system }}, {{ user }}, {{ assistant }}) are removed from the output.
 func Render(content string, vars map[string]any, includes map[string]string) (RenderResult, error) {
-	parsed, fmErr := frontmatter.Parse(content)
-
-	meta := parsed.Meta
-	body := parsed.Body
+	return RenderWithOptions(content, vars, includes, Options{})
+}
 
-	// If no frontmatter was found, render the entire content as a template.
-	if fmErr != nil {
-		body = content
-		meta = frontmatter.Metadata{}
+// RenderWithOptions renders content like Render using opts.
+func RenderWithOptions(content string, vars map[string]any, includes map[string]string, opts Options) (RenderResult, error) {
+	c, err := Compile(content, includes)
+	if err != nil {
+		return RenderResult{}, err
 	}
+	return c.Execute(vars, opts)
+}
 
-	tmpl := template.New("main").Funcs(FuncMap())
+// RenderMessages renders content like Render and additionally splits the
+// output into chat messages at each role block marker. Text before the first
+// marker, or the whole output if the template declares no roles, becomes a
+// user message.
+func RenderMessages(content string, vars map[string]any, includes map[string]string) (RenderResult, error) {
+	return RenderMessagesWithOptions(content, vars, includes, Options{})
+}
 
-	// Register include templates.
-	for name, incBody := range includes {
-		if _, err := tmpl.New(name).Parse(incBody); err != nil {
-			return RenderResult{}, fmt.Errorf("parsing include %q: %w", name, err)
-		}
+// RenderMessagesWithOptions renders content like RenderMessages using opts.
+func RenderMessagesWithOptions(content string, vars map[string]any, includes map[string]string, opts Options) (RenderResult, error) {
+	c, err := Compile(content, includes)
+	if err != nil {
+		return RenderResult{}, err
 	}
+	return c.ExecuteMessages(vars, opts)
+}
 
-	if _, err := tmpl.Parse(body); err != nil {
-		return RenderResult{}, fmt.Errorf("parsing template: %w", err)
+// withDefaults returns vars with the defaults declared in meta filled in for
+// any variable that was not provided. vars itself is never modified.
+func withDefaults(meta frontmatter.Metadata, vars map[string]any) map[string]any {
+	defaults := meta.Defaults()
+	if len(defaults) == 0 {
+		return vars
 	}
 
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, vars); err != nil {
-		return RenderResult{}, fmt.Errorf("executing template: %w", err)
+	merged := make(map[string]any, len(vars)+len(defaults))
+	for k, v := range defaults {
+		merged[k] = v
 	}
-
-	return RenderResult{Output: buf.String(), Meta: meta}, nil
+	for k, v := range vars {
+		merged[k] = v
+	}
+	return merged
 }
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"text/template/parse"
+)
+
+// Parent is an ancestor template in an extends chain.
+type Parent struct {
+	Name    string
+	Content string
+}
+
+// overlay is the body of a template that extends another; its blocks replace
+// the blocks of the same name in its ancestors.
+type overlay struct {
+	name string
+	body string
+	src  source
+}
+
+// CompileExtends compiles content as a template that extends parents, which
+// are ordered from the root ancestor to the direct parent of content. The
+// root ancestor's body is the template that is executed; every descendant
+// may only contain {{ define }} and {{ block }} sections, which replace the
+// blocks of the same name declared by its ancestors. The metadata of each
+// template is merged into that of its parent. Includes are parsed in order,
+// so a template defined by several includes takes the definition of the last.
+func CompileExtends(name, content string, parents []Parent, includes []Include) (*Compiled, error) {
+	if len(parents) == 0 {
+		return compileNamed(name, content, includes)
+	}
+
+	meta, body, offset := split(parents[0].Content)
+	root := source{name: parents[0].Name, offset: offset}
+	_, defined, _, err := blocks(root.name, body)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template %q: %w", root.name, locate(err, map[string]source{root.name: root}))
+	}
+
+	chain := append(parents[1:len(parents):len(parents)], Parent{Name: name, Content: content})
+	overrides := make([]overlay, 0, len(chain))
+	for i, p := range chain {
+		parent := parents[i].Name
+		childMeta, childBody, childOffset := split(p.Content)
+		src := source{name: p.Name, offset: childOffset}
+
+		sections, declared, onlyBlocks, err := blocks(p.Name, childBody)
+		if err != nil {
+			return nil, fmt.Errorf("parsing template %q: %w", p.Name, locate(err, map[string]source{p.Name: src}))
+		}
+		if !onlyBlocks {
+			return nil, fmt.Errorf("template %q extends %q but has content outside {{ define }} and {{ block }} sections", p.Name, parent)
+		}
+		for _, n := range sections {
+			if !slices.Contains(defined, n) {
+				return nil, fmt.Errorf("template %q overrides block %q, which %q does not define", p.Name, n, parent)
+			}
+		}
+
+		meta = childMeta.Inherit(meta)
+		defined = append(defined, declared...)
+		// Prefix the overlay so it cannot replace an include of the same name.
+		overrides = append(overrides, overlay{
+			name: "extends:" + p.Name,
+			body: childBody,
+			src:  src,
+		})
+	}
+
+	return compile(meta, body, root, overrides, includes)
+}
+
+// blocks returns the names of the top-level {{ define }} and {{ block }}
+// sections in body, and reports whether body contains nothing else besides
+// whitespace. Blocks nested inside a section declare new blocks and are not
+// returned; they are added to declared.
+func blocks(name, body string) (sections, declared []string, onlyBlocks bool, err error) {
+	tree := parse.New(name)
+	tree.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	if _, err := tree.Parse(body, "", "", trees); err != nil {
+		return nil, nil, false, err
+	}
+
+	nested := make(map[string]bool)
+	for n, t := range trees {
+		if n != name {
+			collectInvoked(t.Root, nested)
+		}
+	}
+	for n := range trees {
+		if n == name {
+			continue
+		}
+		declared = append(declared, n)
+		if !nested[n] {
+			sections = append(sections, n)
+		}
+	}
+	sort.Strings(sections)
+	sort.Strings(declared)
+
+	onlyBlocks = true
+	if top, ok := trees[name]; ok {
+		for _, node := range top.Root.Nodes {
+			switch n := node.(type) {
+			case *parse.TextNode:
+				if len(bytes.TrimSpace(n.Text)) > 0 {
+					onlyBlocks = false
+				}
+			case *parse.TemplateNode:
+				// A top-level {{ block }} both defines and invokes a section.
+				if _, ok := trees[n.Name]; !ok {
+					onlyBlocks = false
+				}
+			default:
+				onlyBlocks = false
+			}
+		}
+	}
+	return sections, declared, onlyBlocks, nil
+}
+
+// collectInvoked adds the names of the templates invoked below node to names.
+func collectInvoked(node parse.Node, names map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectInvoked(child, names)
+		}
+	case *parse.TemplateNode:
+		names[n.Name] = true
+	case *parse.IfNode:
+		collectInvoked(n.List, names)
+		collectInvoked(n.ElseList, names)
+	case *parse.RangeNode:
+		collectInvoked(n.List, names)
+		collectInvoked(n.ElseList, names)
+	case *parse.WithNode:
+		collectInvoked(n.List, names)
+		collectInvoked(n.ElseList, names)
+	}
+}
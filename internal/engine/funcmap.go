@@ -3,33 +3,139 @@ package engine
 
 import (
 	"encoding/json"
+	"fmt"
 	"strings"
 	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/devaloi/promptkit/internal/tokenizer"
 )
 
-// FuncMap returns the template.FuncMap with all LLM helper functions.
+// FuncMap returns the template.FuncMap with all LLM helper functions. Token
+// helpers use the ~4 chars per token heuristic; rendering rebinds them to the
+// tokenizer selected for the template.
 func FuncMap() template.FuncMap {
+	heuristic := tokenFuncs(tokenizer.Heuristic{})
 	return template.FuncMap{
-		"truncate":       truncate,
-		"json_encode":    jsonEncode,
-		"word_count":     wordCount,
-		"token_estimate": tokenEstimate,
-		"upper":          strings.ToUpper,
-		"lower":          strings.ToLower,
-		"join":           joinSlice,
-		"default":        defaultVal,
+		"truncate":           truncate,
+		"truncate_tokens":    heuristic["truncate_tokens"],
+		"truncate_middle":    heuristic["truncate_middle"],
+		"truncate_sentences": truncateSentences,
+		"fit_budget":         fitBudget,
+		"json_encode":        jsonEncode,
+		"word_count":         wordCount,
+		"token_estimate":     tokenEstimate,
+		"upper":              strings.ToUpper,
+		"lower":              strings.ToLower,
+		"join":               joinSlice,
+		"list":               list,
+		"include":            includeUnbound,
+		"output_schema":      outputSchemaUnbound,
+		"default":            defaultVal,
+		"system":             roleUnbound,
+		"user":               roleUnbound,
+		"assistant":          roleUnbound,
 	}
 }
 
 // truncate limits text to maxChars characters, appending "..." if truncated.
+// Characters are counted as runes, so multi-byte characters are never split.
 func truncate(maxChars int, text string) string {
-	if len(text) <= maxChars {
+	if utf8.RuneCountInString(text) <= maxChars {
 		return text
 	}
+	runes := []rune(text)
 	if maxChars <= 3 {
-		return text[:maxChars]
+		return string(runes[:max(maxChars, 0)])
+	}
+	return string(runes[:maxChars-3]) + "..."
+}
+
+// truncateTokens limits text to maxTokens tokens of tok.
+func truncateTokens(tok tokenizer.Tokenizer, maxTokens int, text string) string {
+	tokens := tok.Split(text)
+	if len(tokens) <= maxTokens {
+		return text
+	}
+	if maxTokens <= 0 {
+		return ""
+	}
+	return trimPartialRunes(strings.Join(tokens[:maxTokens], ""))
+}
+
+// truncateMiddle limits text to maxTokens tokens of tok by keeping its start
+// and end and replacing the middle with "...".
+func truncateMiddle(tok tokenizer.Tokenizer, maxTokens int, text string) string {
+	tokens := tok.Split(text)
+	if len(tokens) <= maxTokens {
+		return text
+	}
+	if maxTokens <= 0 {
+		return ""
+	}
+	head := maxTokens - maxTokens/2
+	tail := maxTokens / 2
+	return trimPartialRunes(strings.Join(tokens[:head], "")) + "..." +
+		trimPartialRunes(strings.Join(tokens[len(tokens)-tail:], ""))
+}
+
+// truncateSentences keeps the first n sentences of text. A sentence ends at
+// ".", "!" or "?" (or their full-width forms) followed by whitespace.
+func truncateSentences(n int, text string) string {
+	if n <= 0 {
+		return ""
 	}
-	return text[:maxChars-3] + "..."
+	count := 0
+	for i, r := range text {
+		if !strings.ContainsRune(".!?。！？", r) {
+			continue
+		}
+		end := i + utf8.RuneLen(r)
+		next, _ := utf8.DecodeRuneInString(text[end:])
+		if end < len(text) && !unicode.IsSpace(next) && r < utf8.RuneSelf {
+			continue
+		}
+		count++
+		if count == n {
+			return text[:end]
+		}
+	}
+	return text
+}
+
+// trimPartialRunes drops incomplete UTF-8 sequences left at either end of s
+// by splitting on token boundaries.
+func trimPartialRunes(s string) string {
+	for len(s) > 0 && !utf8.RuneStart(s[0]) {
+		s = s[1:]
+	}
+	for len(s) > 0 {
+		r, size := utf8.DecodeLastRuneInString(s)
+		if r != utf8.RuneError || size > 1 {
+			break
+		}
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// fitBudget returns text unchanged. Rendering replaces it with a version that
+// trims the section when the template's token_budget is exceeded.
+func fitBudget(_ string, _ int, text string) string {
+	return text
+}
+
+// includeUnbound fails: include needs the template's includes, which are
+// bound when a compiled template is executed.
+func includeUnbound(name string, _ ...any) (string, error) {
+	return "", fmt.Errorf("include %q: no includes are available", name)
+}
+
+// list returns its arguments as a list, for passing list values to helpers
+// and includes.
+func list(items ...any) []any {
+	return items
 }
 
 // jsonEncode marshals a value to a JSON string.
@@ -48,11 +154,21 @@ func wordCount(text string) int {
 
 // tokenEstimate estimates the number of tokens in text (~4 chars per token).
 func tokenEstimate(text string) int {
-	n := len(text)
-	if n == 0 {
-		return 0
+	return tokenizer.Heuristic{}.Count(text)
+}
+
+// tokenFuncs returns the token helpers bound to tok. They replace the
+// heuristic defaults from FuncMap when a template is executed.
+func tokenFuncs(tok tokenizer.Tokenizer) template.FuncMap {
+	return template.FuncMap{
+		"token_estimate": tok.Count,
+		"truncate_tokens": func(maxTokens int, text string) string {
+			return truncateTokens(tok, maxTokens, text)
+		},
+		"truncate_middle": func(maxTokens int, text string) string {
+			return truncateMiddle(tok, maxTokens, text)
+		},
 	}
-	return (n + 3) / 4
 }
 
 // joinSlice joins a slice of strings with the given separator.
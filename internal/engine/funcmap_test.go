@@ -2,6 +2,9 @@ package engine
 
 import (
 	"testing"
+	"unicode/utf8"
+
+	"github.com/devaloi/promptkit/internal/tokenizer"
 )
 
 func TestTruncate(t *testing.T) {
@@ -16,6 +19,8 @@ func TestTruncate(t *testing.T) {
 		{"truncated with ellipsis", 8, "hello world", "hello..."},
 		{"very short max", 2, "hello", "he"},
 		{"empty string", 10, "", ""},
+		{"multi-byte runes", 5, "héllo wörld", "hé..."},
+		{"multi-byte short max", 2, "日本語", "日本"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -138,10 +143,96 @@ func TestDefaultVal(t *testing.T) {
 
 func TestFuncMapRegistered(t *testing.T) {
 	fm := FuncMap()
-	expected := []string{"truncate", "json_encode", "word_count", "token_estimate", "upper", "lower", "join", "default"}
+	expected := []string{"truncate", "json_encode", "word_count", "token_estimate", "upper", "lower", "join", "default", "system", "user", "assistant",
+		"truncate_tokens", "truncate_middle", "truncate_sentences", "fit_budget", "list", "include", "output_schema",
+	}
 	for _, name := range expected {
 		if _, ok := fm[name]; !ok {
 			t.Errorf("FuncMap missing function %q", name)
 		}
 	}
 }
+
+func TestTruncateTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int
+		input    string
+		expected string
+	}{
+		{"fits", 3, "hello world", "hello world"},
+		{"truncated", 2, "hello world", "hello wo"},
+		{"zero", 0, "hello", ""},
+		{"multi-byte", 1, "héllo", "hél"},
+		{"whole runes", 1, "日本語", "日本"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateTokens(tokenizer.Heuristic{}, tt.max, tt.input)
+			if got != tt.expected {
+				t.Errorf("truncateTokens(%d, %q) = %q, want %q", tt.max, tt.input, got, tt.expected)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncateTokens(%d, %q) produced invalid UTF-8", tt.max, tt.input)
+			}
+		})
+	}
+}
+
+func TestTruncateMiddle(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int
+		input    string
+		expected string
+	}{
+		{"fits", 10, "short", "short"},
+		{"keeps head and tail", 3, "aaaabbbbccccddddeeee", "aaaabbbb...eeee"},
+		{"zero", 0, "aaaabbbb", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateMiddle(tokenizer.Heuristic{}, tt.max, tt.input)
+			if got != tt.expected {
+				t.Errorf("truncateMiddle(%d, %q) = %q, want %q", tt.max, tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTruncateSentences(t *testing.T) {
+	tests := []struct {
+		name     string
+		n        int
+		input    string
+		expected string
+	}{
+		{"first sentence", 1, "One. Two! Three?", "One."},
+		{"two sentences", 2, "One. Two! Three?", "One. Two!"},
+		{"fewer sentences", 5, "Only one.", "Only one."},
+		{"decimal is not a break", 1, "Pi is 3.14 roughly. Next.", "Pi is 3.14 roughly."},
+		{"full-width", 1, "第一句。第二句。", "第一句。"},
+		{"zero", 0, "One. Two.", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateSentences(tt.n, tt.input)
+			if got != tt.expected {
+				t.Errorf("truncateSentences(%d, %q) = %q, want %q", tt.n, tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTruncateTokens_SplitRune(t *testing.T) {
+	// A byte-level tokenizer can end a token mid-rune; the partial rune is dropped.
+	got := truncateTokens(fixedTokenizer{}, 2, "héllo")
+	if got != "h" {
+		t.Errorf("expected %q, got %q", "h", got)
+	}
+
+	got = truncateMiddle(fixedTokenizer{}, 8, "日本語日本語")
+	if got != "日...語" {
+		t.Errorf("expected %q, got %q", "日...語", got)
+	}
+}
@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"text/template"
+
+	"github.com/devaloi/promptkit/internal/frontmatter"
+	"github.com/devaloi/promptkit/internal/validator"
+)
+
+// Include is an include template: its file name without extension and its
+// content, which may start with frontmatter.
+type Include struct {
+	Name    string
+	Content string
+}
+
+// sortedIncludes returns includes ordered by name.
+func sortedIncludes(includes map[string]string) []Include {
+	sorted := make([]Include, 0, len(includes))
+	for name, content := range includes {
+		sorted = append(sorted, Include{Name: name, Content: content})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+// includeParams returns the params declared in the frontmatter of each
+// include.
+func includeParams(includes []Include) map[string]map[string]frontmatter.VarSpec {
+	params := make(map[string]map[string]frontmatter.VarSpec, len(includes))
+	for _, inc := range includes {
+		meta, _, _ := split(inc.Content)
+		params[inc.Name] = meta.Params
+	}
+	return params
+}
+
+// include returns the include helper bound to the templates in tmpl. It
+// renders an include with named arguments:
+//
+//	{{ include "json_format" "fields" (list "summary" "confidence") }}
+//
+// The arguments are validated against the params declared in the include's
+// frontmatter, defaults are filled in, and the include is executed with the
+// arguments as dot.
+func (c *Compiled) include(tmpl *template.Template) func(string, ...any) (string, error) {
+	return func(name string, args ...any) (string, error) {
+		params, ok := c.params[name]
+		t := tmpl.Lookup(name)
+		if !ok || t == nil {
+			return "", fmt.Errorf("include %q is not defined", name)
+		}
+
+		data, err := includeArgs(args)
+		if err != nil {
+			return "", fmt.Errorf("include %q: %w", name, err)
+		}
+		if err := checkParams(params, data); err != nil {
+			return "", fmt.Errorf("include %q: %w", name, err)
+		}
+		data = withDefaults(frontmatter.Metadata{Vars: params}, data)
+
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+}
+
+// includeArgs pairs include arguments into a map of parameter names to
+// values.
+func includeArgs(args []any) (map[string]any, error) {
+	if len(args)%2 != 0 {
+		return nil, fmt.Errorf("arguments must be name and value pairs, got %d values", len(args))
+	}
+	data := make(map[string]any, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		key, ok := args[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("parameter name must be a string, got %T", args[i])
+		}
+		data[key] = args[i+1]
+	}
+	return data, nil
+}
+
+// checkParams validates args against the declared params. An include that
+// declares no params accepts any arguments.
+func checkParams(params map[string]frontmatter.VarSpec, args map[string]any) error {
+	if len(params) == 0 {
+		return nil
+	}
+
+	var unknown []validator.FieldError
+	for name := range args {
+		if _, ok := params[name]; !ok {
+			unknown = append(unknown, validator.FieldError{Var: name, Rule: validator.RuleUnknown, Message: "is not a declared parameter"})
+		}
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Var < unknown[j].Var })
+
+	err := validator.ValidateMeta(frontmatter.Metadata{Vars: params}, args)
+	if len(unknown) == 0 {
+		return err
+	}
+	var vErr *validator.VarsError
+	if errors.As(err, &vErr) {
+		unknown = append(unknown, vErr.Errors...)
+	}
+	return &validator.VarsError{Errors: unknown}
+}
@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Role identifies the author of a chat message.
+type Role string
+
+// Chat message roles understood by LLM chat APIs.
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// Message is a single role-tagged chat message produced by RenderMessages.
+type Message struct {
+	Role    Role   `json:"role"`
+	Content string `json:"content"`
+}
+
+// known reports whether r is one of the chat message roles.
+func (r Role) known() bool {
+	return r == RoleSystem || r == RoleUser || r == RoleAssistant
+}
+
+// markerSuffix ends a role marker. The control character keeps markers from
+// colliding with anything a prompt would contain.
+const markerSuffix = "\x1e"
+
+// roleMarkers delimits role blocks in the raw output of one execution. Each
+// execution uses a random nonce, so variable values that happen to contain
+// marker text cannot start a block of their own.
+type roleMarkers struct {
+	prefix string
+}
+
+func newRoleMarkers() roleMarkers {
+	return roleMarkers{prefix: "\x1epromptkit:" + rand.Text() + ":role="}
+}
+
+func (m roleMarkers) marker(role Role) string {
+	return m.prefix + string(role) + markerSuffix
+}
+
+// funcs returns the role template functions bound to m.
+func (m roleMarkers) funcs() template.FuncMap {
+	fn := func(role Role) func() string {
+		return func() string { return m.marker(role) }
+	}
+	return template.FuncMap{
+		"system":    fn(RoleSystem),
+		"user":      fn(RoleUser),
+		"assistant": fn(RoleAssistant),
+	}
+}
+
+// roleUnbound stands in for the role functions until a template is executed.
+func roleUnbound() string {
+	return ""
+}
+
+// strip removes role block markers, leaving plain prompt text.
+func (m roleMarkers) strip(output string) string {
+	if !strings.Contains(output, m.prefix) {
+		return output
+	}
+	for _, role := range []Role{RoleSystem, RoleUser, RoleAssistant} {
+		output = strings.ReplaceAll(output, m.marker(role), "")
+	}
+	return output
+}
+
+// split splits rendered output into role-tagged messages. Each role block
+// runs until the next marker; text before the first marker belongs to the
+// user. Surrounding whitespace is trimmed and empty blocks are dropped.
+func (m roleMarkers) split(output string) ([]Message, error) {
+	var messages []Message
+	add := func(role Role, content string) {
+		content = strings.TrimSpace(content)
+		if content != "" {
+			messages = append(messages, Message{Role: role, Content: content})
+		}
+	}
+
+	role := RoleUser
+	for {
+		start := strings.Index(output, m.prefix)
+		if start < 0 {
+			add(role, output)
+			return messages, nil
+		}
+		rest := output[start+len(m.prefix):]
+		end := strings.Index(rest, markerSuffix)
+		if end < 0 {
+			add(role, output)
+			return messages, nil
+		}
+		add(role, output[:start])
+		role = Role(rest[:end])
+		if !role.known() {
+			return nil, fmt.Errorf("unknown message role %q", role)
+		}
+		output = rest[end+len(markerSuffix):]
+	}
+}
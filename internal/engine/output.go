@@ -0,0 +1,34 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// errNoOutputSchema is returned by the output_schema helper in templates that
+// declare no output_schema.
+var errNoOutputSchema = errors.New("output_schema: template declares no output_schema")
+
+// outputSchema is the output_schema helper bound to c. It renders the
+// template's output_schema as indented JSON Schema, so a prompt can show the
+// model the shape of the expected response:
+//
+//	Respond with JSON matching this schema:
+//	{{ output_schema }}
+func (c *Compiled) outputSchema() (string, error) {
+	if c.Meta.OutputSchema == nil {
+		return "", errNoOutputSchema
+	}
+	data, err := json.MarshalIndent(c.Meta.OutputSchema, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("output_schema: %w", err)
+	}
+	return string(data), nil
+}
+
+// outputSchemaUnbound fails: output_schema needs the template's metadata,
+// which is bound when a compiled template is executed.
+func outputSchemaUnbound() (string, error) {
+	return "", errNoOutputSchema
+}
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// strictFuncName is appended to every output action in strict mode. It is
+// only registered for strict renders, so templates cannot call it directly.
+const strictFuncName = "_strict"
+
+// RenderError reports a template execution failure with the location of the
+// offending action.
+type RenderError struct {
+	Template string
+	Line     int
+	Column   int
+	// Action is the action being evaluated, e.g. ".document".
+	Action  string
+	Message string
+	Err     error
+}
+
+func (e *RenderError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: at <%s>: %s", e.Template, e.Line, e.Column, e.Action, e.Message)
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// execErrorPattern matches the message of a text/template ExecError:
+// `template: name:line:col: executing "name" at <action>: message`.
+var execErrorPattern = regexp.MustCompile(`(?s)^template: (.+?):(\d+):(\d+): executing "[^"]*" at <(.*?)>: (.*)$`)
+
+// newRenderError converts a text/template execution error into a
+// *RenderError, locating it in the file named by sources. Errors that do not
+// carry a location are returned unchanged.
+func newRenderError(err error, sources map[string]source) error {
+	var execErr template.ExecError
+	if !errors.As(err, &execErr) {
+		return err
+	}
+
+	m := execErrorPattern.FindStringSubmatch(execErr.Err.Error())
+	if m == nil {
+		return err
+	}
+
+	line, _ := strconv.Atoi(m[2])
+	col, _ := strconv.Atoi(m[3])
+	rerr := &RenderError{
+		Template: m[1],
+		Line:     line,
+		Column:   col,
+		Action:   m[4],
+		Message:  m[5],
+		Err:      err,
+	}
+	if src, ok := sources[rerr.Template]; ok {
+		rerr.Template = src.name
+		rerr.Line += src.offset
+	}
+
+	// Nil checks inserted by strict mode report the original action.
+	if rest, ok := strings.CutPrefix(rerr.Action, strictFuncName+" "); ok {
+		if quoted, err := strconv.QuotedPrefix(rest); err == nil {
+			rerr.Action, _ = strconv.Unquote(quoted)
+		}
+		rerr.Message = strings.TrimPrefix(rerr.Message, "error calling "+strictFuncName+": ")
+	}
+	return rerr
+}
+
+// strictValue passes value through, failing if it is nil.
+func strictValue(action string, value any) (any, error) {
+	if value == nil {
+		return nil, fmt.Errorf("%s is nil", action)
+	}
+	return value, nil
+}
+
+// instrumentStrict adds nil checks to tmpl and its associated templates: every
+// field, variable and chain argument of every command is checked before it is
+// used, in actions as well as in the pipelines of if, with, range and
+// template, and the result of every output action is checked before it is
+// printed. Actions that only declare variables print nothing, so only their
+// arguments are checked.
+func instrumentStrict(tmpl *template.Template) {
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			instrumentNode(t.Tree, t.Tree.Root)
+		}
+	}
+}
+
+func instrumentNode(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			instrumentNode(tree, child)
+		}
+	case *parse.IfNode:
+		instrumentPipe(tree, n.Pipe)
+		instrumentNode(tree, n.List)
+		instrumentNode(tree, n.ElseList)
+	case *parse.RangeNode:
+		instrumentPipe(tree, n.Pipe)
+		instrumentNode(tree, n.List)
+		instrumentNode(tree, n.ElseList)
+	case *parse.WithNode:
+		instrumentPipe(tree, n.Pipe)
+		instrumentNode(tree, n.List)
+		instrumentNode(tree, n.ElseList)
+	case *parse.TemplateNode:
+		instrumentPipe(tree, n.Pipe)
+	case *parse.ActionNode:
+		action := n.Pipe.String()
+		instrumentPipe(tree, n.Pipe)
+		if len(n.Pipe.Decl) > 0 {
+			return
+		}
+		n.Pipe.Cmds = append(n.Pipe.Cmds, strictCommand(tree, n.Pos, action))
+	}
+}
+
+// instrumentPipe wraps the field, variable and chain arguments of every
+// command in pipe, and of the pipelines nested in them, in a nil check.
+func instrumentPipe(tree *parse.Tree, pipe *parse.PipeNode) {
+	if pipe == nil {
+		return
+	}
+	for _, cmd := range pipe.Cmds {
+		for i, arg := range cmd.Args {
+			switch a := arg.(type) {
+			case *parse.PipeNode:
+				instrumentPipe(tree, a)
+				continue
+			case *parse.ChainNode:
+				if p, ok := a.Node.(*parse.PipeNode); ok {
+					instrumentPipe(tree, p)
+				}
+			case *parse.FieldNode, *parse.VariableNode:
+			default:
+				continue
+			}
+			// A field or variable followed by arguments is a method call;
+			// its result is checked where it is used.
+			if i == 0 && len(cmd.Args) > 1 {
+				continue
+			}
+			check := strictCommand(tree, arg.Position(), arg.String())
+			check.Args = append(check.Args, arg)
+			cmd.Args[i] = &parse.PipeNode{NodeType: parse.NodePipe, Pos: arg.Position(), Cmds: []*parse.CommandNode{check}}
+		}
+	}
+}
+
+// strictCommand returns a command that calls the nil check for action on its
+// last argument, or on the value piped into it.
+func strictCommand(tree *parse.Tree, pos parse.Pos, action string) *parse.CommandNode {
+	return &parse.CommandNode{
+		NodeType: parse.NodeCommand,
+		Pos:      pos,
+		Args: []parse.Node{
+			parse.NewIdentifier(strictFuncName).SetTree(tree).SetPos(pos),
+			&parse.StringNode{NodeType: parse.NodeString, Pos: pos, Quoted: strconv.Quote(action), Text: action},
+		},
+	}
+}
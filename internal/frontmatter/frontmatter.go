@@ -3,6 +3,11 @@ package frontmatter
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -10,27 +15,215 @@ import (
 
 // Metadata holds parsed YAML frontmatter fields from a template file.
 type Metadata struct {
-	Name         string   `yaml:"name"`
-	Description  string   `yaml:"description"`
-	RequiredVars []string `yaml:"required_vars"`
-	ModelHint    string   `yaml:"model_hint"`
+	Name         string             `yaml:"name"`
+	Description  string             `yaml:"description"`
+	RequiredVars []string           `yaml:"required_vars"`
+	Vars         map[string]VarSpec `yaml:"vars"`
+	ModelHint    string             `yaml:"model_hint"`
+	TokenBudget  int                `yaml:"token_budget"`
+
+	// Version is the template's semantic version, such as "1.2.0".
+	Version string `yaml:"version"`
+
+	// Extends names the template this one inherits from.
+	Extends string `yaml:"extends"`
+
+	// Params declares the named arguments of an include called with the
+	// include helper.
+	Params map[string]VarSpec `yaml:"params"`
+
+	// OutputSchema describes the JSON the prompt asks the model to return.
+	OutputSchema *Schema `yaml:"output_schema"`
+
+	// Generation holds the model settings the prompt is meant to be sent
+	// with.
+	Generation Generation `yaml:"generation"`
+}
+
+// Inherit returns m merged over the metadata of the template it extends.
+// Fields set in m take precedence; required_vars are combined, parent first,
+// vars declared in both use m's declaration, and generation settings are
+// merged field by field.
+func (m Metadata) Inherit(parent Metadata) Metadata {
+	merged := m
+	if merged.Description == "" {
+		merged.Description = parent.Description
+	}
+	if merged.ModelHint == "" {
+		merged.ModelHint = parent.ModelHint
+	}
+	if merged.TokenBudget == 0 {
+		merged.TokenBudget = parent.TokenBudget
+	}
+	if merged.OutputSchema == nil {
+		merged.OutputSchema = parent.OutputSchema
+	}
+	merged.Generation = m.Generation.over(parent.Generation)
+
+	merged.RequiredVars = nil
+	for _, name := range append(slices.Clone(parent.RequiredVars), m.RequiredVars...) {
+		if !slices.Contains(merged.RequiredVars, name) {
+			merged.RequiredVars = append(merged.RequiredVars, name)
+		}
+	}
+
+	if len(parent.Vars) > 0 {
+		merged.Vars = make(map[string]VarSpec, len(parent.Vars)+len(m.Vars))
+		for name, spec := range parent.Vars {
+			merged.Vars[name] = spec
+		}
+		for name, spec := range m.Vars {
+			merged.Vars[name] = spec
+		}
+	}
+	return merged
+}
+
+// Variable types accepted in VarSpec.Type.
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeList   = "list"
+	TypeObject = "object"
+)
+
+// VarSpec declares the type, default and constraints of a template variable
+// in the frontmatter vars section.
+type VarSpec struct {
+	Type        string `yaml:"type"`
+	Description string `yaml:"description"`
+	Required    bool   `yaml:"required"`
+	Default     any    `yaml:"default"`
+	Enum        []any  `yaml:"enum"`
+	Pattern     string `yaml:"pattern"`
+	MinLength   *int   `yaml:"min_length"`
+	MaxLength   *int   `yaml:"max_length"`
+}
+
+// Schema is the subset of JSON Schema accepted in output_schema. Keywords
+// use their JSON Schema spelling, so a schema marshals back to valid JSON
+// Schema.
+type Schema struct {
+	// Type is one of object, array, string, number, integer, boolean or
+	// null. An empty type accepts any value.
+	Type        string `yaml:"type" json:"type,omitempty"`
+	Description string `yaml:"description" json:"description,omitempty"`
+
+	Properties map[string]*Schema `yaml:"properties" json:"properties,omitempty"`
+	Required   []string           `yaml:"required" json:"required,omitempty"`
+
+	// AdditionalProperties rejects properties not listed in Properties when
+	// set to false.
+	AdditionalProperties *bool `yaml:"additionalProperties" json:"additionalProperties,omitempty"`
+
+	Items    *Schema `yaml:"items" json:"items,omitempty"`
+	MinItems *int    `yaml:"minItems" json:"minItems,omitempty"`
+	MaxItems *int    `yaml:"maxItems" json:"maxItems,omitempty"`
+
+	Enum      []any    `yaml:"enum" json:"enum,omitempty"`
+	Pattern   string   `yaml:"pattern" json:"pattern,omitempty"`
+	MinLength *int     `yaml:"minLength" json:"minLength,omitempty"`
+	MaxLength *int     `yaml:"maxLength" json:"maxLength,omitempty"`
+	Minimum   *float64 `yaml:"minimum" json:"minimum,omitempty"`
+	Maximum   *float64 `yaml:"maximum" json:"maximum,omitempty"`
+}
+
+// Required returns the names of all required variables: every entry of
+// required_vars followed by vars declared with required: true. A variable
+// with a default value is never required.
+func (m Metadata) Required() []string {
+	var names []string
+	for _, name := range m.RequiredVars {
+		if spec, ok := m.Vars[name]; ok && spec.Default != nil {
+			continue
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	var declared []string
+	for name, spec := range m.Vars {
+		if spec.Required && spec.Default == nil && !slices.Contains(names, name) {
+			declared = append(declared, name)
+		}
+	}
+	sort.Strings(declared)
+
+	return append(names, declared...)
+}
+
+// Defaults returns the default values declared in the vars section.
+func (m Metadata) Defaults() map[string]any {
+	defaults := make(map[string]any)
+	for name, spec := range m.Vars {
+		if spec.Default != nil {
+			defaults[name] = spec.Default
+		}
+	}
+	return defaults
 }
 
 // Result contains parsed frontmatter metadata and the remaining template body.
 type Result struct {
 	Meta Metadata
 	Body string
+
+	// Offset is the number of lines of the content that precede Body, so
+	// line n of Body is line n+Offset of the content.
+	Offset int
 }
 
 // ErrNoFrontmatter indicates the template has no YAML frontmatter delimiters.
 var ErrNoFrontmatter = errors.New("no frontmatter found")
 
+// ParseError reports frontmatter that is not valid YAML.
+type ParseError struct {
+	// Line is the 1-based line in the template content where the first
+	// problem was found, or 0 if the YAML decoder did not report one.
+	Line int
+
+	// Message describes the problem, with line numbers relative to the
+	// template content.
+	Message string
+
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return "invalid frontmatter: " + e.Message
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// yamlLinePattern matches the line references in yaml.v3 error messages.
+var yamlLinePattern = regexp.MustCompile(`line (\d+)`)
+
+// newParseError converts a YAML decoding error for frontmatter that starts
+// offset lines into the template content.
+func newParseError(err error, offset int) *ParseError {
+	pe := &ParseError{Err: err}
+	msg := strings.TrimPrefix(err.Error(), "yaml: ")
+	pe.Message = yamlLinePattern.ReplaceAllStringFunc(msg, func(m string) string {
+		n, _ := strconv.Atoi(strings.TrimPrefix(m, "line "))
+		if pe.Line == 0 {
+			pe.Line = n + offset
+		}
+		return fmt.Sprintf("line %d", n+offset)
+	})
+	return pe
+}
+
 const delimiter = "---"
 
 // Parse splits a template string into YAML frontmatter metadata and a body.
 // Frontmatter must be delimited by lines containing only "---".
 // If no frontmatter is present, ErrNoFrontmatter is returned and the full
-// content is placed in Result.Body.
+// content is placed in Result.Body. Malformed YAML yields a *ParseError.
 func Parse(content string) (Result, error) {
 	trimmed := strings.TrimSpace(content)
 	if !strings.HasPrefix(trimmed, delimiter) {
@@ -47,11 +240,14 @@ func Parse(content string) (Result, error) {
 	rawYAML := rest[:idx]
 	body := rest[idx+len("\n"+delimiter):]
 	body = strings.TrimLeft(body, "\r\n")
+	start := strings.Index(content, delimiter) + len(delimiter) + len(rest) - len(body)
 
 	var meta Metadata
 	if err := yaml.Unmarshal([]byte(rawYAML), &meta); err != nil {
-		return Result{}, err
+		// The YAML starts on the line of the opening delimiter.
+		offset := strings.Count(content[:strings.Index(content, delimiter)], "\n")
+		return Result{}, newParseError(err, offset)
 	}
 
-	return Result{Meta: meta, Body: body}, nil
+	return Result{Meta: meta, Body: body, Offset: strings.Count(content[:start], "\n")}, nil
 }
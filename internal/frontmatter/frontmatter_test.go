@@ -2,6 +2,7 @@ package frontmatter
 
 import (
 	"errors"
+	"slices"
 	"testing"
 )
 
@@ -39,6 +40,9 @@ Summarize the following document.`
 	if result.Body != "Summarize the following document." {
 		t.Errorf("unexpected body: %q", result.Body)
 	}
+	if result.Offset != 8 {
+		t.Errorf("expected body offset 8, got %d", result.Offset)
+	}
 }
 
 func TestParse_MissingFrontmatter(t *testing.T) {
@@ -102,3 +106,215 @@ description: No body content
 		t.Errorf("expected empty body, got %q", result.Body)
 	}
 }
+
+func TestParse_VarsSchema(t *testing.T) {
+	input := `---
+name: summarize
+required_vars:
+  - document
+vars:
+  max_words:
+    type: int
+    default: 100
+    description: Summary length in words
+  tone:
+    type: string
+    required: true
+    enum: [formal, casual]
+---
+Body`
+
+	result, err := Parse(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spec, ok := result.Meta.Vars["max_words"]
+	if !ok {
+		t.Fatal("expected max_words in vars")
+	}
+	if spec.Type != TypeInt || spec.Default != 100 || spec.Description != "Summary length in words" {
+		t.Errorf("unexpected max_words spec: %+v", spec)
+	}
+	if len(result.Meta.Vars["tone"].Enum) != 2 {
+		t.Errorf("expected 2 enum choices, got %v", result.Meta.Vars["tone"].Enum)
+	}
+
+	required := result.Meta.Required()
+	if len(required) != 2 || required[0] != "document" || required[1] != "tone" {
+		t.Errorf("expected [document tone], got %v", required)
+	}
+
+	defaults := result.Meta.Defaults()
+	if len(defaults) != 1 || defaults["max_words"] != 100 {
+		t.Errorf("expected max_words default, got %v", defaults)
+	}
+}
+
+func TestMetadata_RequiredSkipsDefaults(t *testing.T) {
+	meta := Metadata{
+		RequiredVars: []string{"a", "b", "a"},
+		Vars: map[string]VarSpec{
+			"b": {Default: "x"},
+		},
+	}
+
+	required := meta.Required()
+	if len(required) != 1 || required[0] != "a" {
+		t.Errorf("expected [a], got %v", required)
+	}
+}
+
+func TestParse_MalformedYAML(t *testing.T) {
+	content := "\n---\nname: broken\nbad: : :\n---\nBody"
+	_, err := Parse(content)
+
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected *ParseError, got %v", err)
+	}
+	if pe.Line != 4 {
+		t.Errorf("expected line 4, got %d (%v)", pe.Line, err)
+	}
+
+	_, err = Parse("---\nname: x\nvars:\n  a: [1, 2]\n---\nBody")
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected *ParseError, got %v", err)
+	}
+	if pe.Line != 4 {
+		t.Errorf("expected line 4, got %d (%v)", pe.Line, err)
+	}
+}
+
+func TestMetadata_Inherit(t *testing.T) {
+	parent := Metadata{
+		Name:         "base",
+		Description:  "Base prompt",
+		RequiredVars: []string{"task", "tone"},
+		Vars:         map[string]VarSpec{"tone": {Type: TypeString}, "length": {Type: TypeInt}},
+		ModelHint:    "gpt-4",
+		TokenBudget:  1000,
+		OutputSchema: &Schema{Type: "object"},
+	}
+	child := Metadata{
+		Name:         "child",
+		Extends:      "base",
+		RequiredVars: []string{"tone", "audience"},
+		Vars:         map[string]VarSpec{"tone": {Type: TypeString, Enum: []any{"formal"}}},
+		TokenBudget:  500,
+	}
+
+	merged := child.Inherit(parent)
+	if merged.Name != "child" || merged.Extends != "base" {
+		t.Errorf("unexpected identity: %q extends %q", merged.Name, merged.Extends)
+	}
+	if merged.Description != "Base prompt" || merged.ModelHint != "gpt-4" {
+		t.Errorf("expected inherited description and model hint, got %+v", merged)
+	}
+	if merged.OutputSchema != parent.OutputSchema {
+		t.Errorf("expected inherited output schema, got %+v", merged.OutputSchema)
+	}
+	if merged.TokenBudget != 500 {
+		t.Errorf("expected child token budget, got %d", merged.TokenBudget)
+	}
+	if !slices.Equal(merged.RequiredVars, []string{"task", "tone", "audience"}) {
+		t.Errorf("unexpected required vars: %v", merged.RequiredVars)
+	}
+	if len(merged.Vars) != 2 || len(merged.Vars["tone"].Enum) != 1 {
+		t.Errorf("unexpected vars: %+v", merged.Vars)
+	}
+	if len(parent.Vars["tone"].Enum) != 0 {
+		t.Error("Inherit modified the parent")
+	}
+}
+
+func TestParse_Generation(t *testing.T) {
+	content := `---
+model_hint: gpt-4
+generation:
+  model: gpt-4o
+  temperature: 0.2
+  top_p: 0.9
+  max_tokens: 512
+  stop: ["\n\n"]
+  seed: 7
+  response_format: json_object
+  overrides:
+    claude:
+      model: claude-3-5-sonnet
+      max_tokens: 1024
+    claude-3-haiku:
+      temperature: 0
+---
+body`
+	result, err := Parse(content)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	meta := result.Meta
+	if meta.Model() != "gpt-4o" {
+		t.Errorf("expected generation model, got %q", meta.Model())
+	}
+
+	gen := meta.GenerationFor("")
+	if gen.Model != "gpt-4o" || *gen.Temperature != 0.2 || *gen.TopP != 0.9 || gen.MaxTokens != 512 ||
+		!slices.Equal(gen.Stop, []string{"\n\n"}) || *gen.Seed != 7 || gen.ResponseFormat != FormatJSONObject || gen.Overrides != nil {
+		t.Errorf("unexpected settings: %+v", gen)
+	}
+
+	tests := []struct {
+		model       string
+		wantModel   string
+		temperature float64
+		maxTokens   int
+	}{
+		{"gpt-4o-mini", "gpt-4o-mini", 0.2, 512},
+		{"claude-3-opus", "claude-3-opus", 0.2, 1024},
+		{"claude-3-haiku-20240307", "claude-3-haiku-20240307", 0, 512},
+	}
+	for _, tt := range tests {
+		gen := meta.GenerationFor(tt.model)
+		if gen.Model != tt.wantModel || *gen.Temperature != tt.temperature || gen.MaxTokens != tt.maxTokens {
+			t.Errorf("GenerationFor(%q) = model %q, temperature %v, max_tokens %d", tt.model, gen.Model, *gen.Temperature, gen.MaxTokens)
+		}
+	}
+
+	// An override may replace the template's own model, but not one asked for.
+	hinted := Metadata{ModelHint: "gpt-4", Generation: Generation{Overrides: map[string]Generation{"gpt-4": {Model: "gpt-4o"}}}}
+	if got := hinted.GenerationFor("").Model; got != "gpt-4o" {
+		t.Errorf("expected override model for the template's own model, got %q", got)
+	}
+	if got := hinted.GenerationFor("gpt-4-turbo").Model; got != "gpt-4-turbo" {
+		t.Errorf("expected explicit model to win, got %q", got)
+	}
+
+	if got := (Metadata{ModelHint: "gpt-4"}).Model(); got != "gpt-4" {
+		t.Errorf("expected model_hint fallback, got %q", got)
+	}
+}
+
+func TestMetadata_InheritGeneration(t *testing.T) {
+	low, high := 0.1, 0.9
+	parent := Metadata{Generation: Generation{
+		Model:       "gpt-4o",
+		Temperature: &low,
+		MaxTokens:   100,
+		Overrides:   map[string]Generation{"claude": {MaxTokens: 200, Stop: []string{"END"}}},
+	}}
+	child := Metadata{Generation: Generation{
+		Temperature: &high,
+		Overrides:   map[string]Generation{"claude": {MaxTokens: 300}},
+	}}
+
+	merged := child.Inherit(parent).Generation
+	if merged.Model != "gpt-4o" || *merged.Temperature != 0.9 || merged.MaxTokens != 100 {
+		t.Errorf("unexpected merged settings: %+v", merged)
+	}
+	claude := merged.Overrides["claude"]
+	if claude.MaxTokens != 300 || !slices.Equal(claude.Stop, []string{"END"}) {
+		t.Errorf("unexpected merged override: %+v", claude)
+	}
+	if parent.Generation.Overrides["claude"].MaxTokens != 200 {
+		t.Error("Inherit modified the parent")
+	}
+}
@@ -0,0 +1,127 @@
+package frontmatter
+
+import (
+	"slices"
+	"strings"
+)
+
+// Response formats accepted in Generation.ResponseFormat.
+const (
+	FormatText       = "text"
+	FormatJSONObject = "json_object"
+	FormatJSONSchema = "json_schema"
+)
+
+// Generation holds the settings a prompt is meant to be sent to a model
+// with, declared in the frontmatter generation section. Unset fields are left
+// to the provider's defaults.
+type Generation struct {
+	Model       string   `yaml:"model" json:"model,omitempty"`
+	Temperature *float64 `yaml:"temperature" json:"temperature,omitempty"`
+	TopP        *float64 `yaml:"top_p" json:"top_p,omitempty"`
+	MaxTokens   int      `yaml:"max_tokens" json:"max_tokens,omitempty"`
+	Stop        []string `yaml:"stop" json:"stop,omitempty"`
+	Seed        *int     `yaml:"seed" json:"seed,omitempty"`
+
+	// ResponseFormat is text, json_object or json_schema; json_schema asks
+	// for a response matching output_schema.
+	ResponseFormat string `yaml:"response_format" json:"response_format,omitempty"`
+
+	// Overrides maps model name prefixes to settings that replace the ones
+	// above when the prompt is sent to a matching model; see For.
+	Overrides map[string]Generation `yaml:"overrides" json:"overrides,omitempty"`
+}
+
+// Model returns the model the template is written for: generation.model, or
+// model_hint if that is unset.
+func (m Metadata) Model() string {
+	if m.Generation.Model != "" {
+		return m.Generation.Model
+	}
+	return m.ModelHint
+}
+
+// GenerationFor returns the generation settings for sending the template to
+// model, or to the template's own model if model is empty. See
+// Generation.For; only in the second case may an override replace the model.
+func (m Metadata) GenerationFor(model string) Generation {
+	if model == "" {
+		return m.Generation.resolve(m.Model())
+	}
+	return m.Generation.For(model)
+}
+
+// For returns the settings for model: g with the override whose key is the
+// longest prefix of model applied on top. The result has Model set to model,
+// even if the override names a model of its own, and no Overrides.
+func (g Generation) For(model string) Generation {
+	resolved := g.resolve(model)
+	if model != "" {
+		resolved.Model = model
+	}
+	return resolved
+}
+
+// resolve returns g for model like For, except that an override that names a
+// model replaces model.
+func (g Generation) resolve(model string) Generation {
+	resolved := g
+	resolved.Overrides = nil
+	if model != "" {
+		resolved.Model = model
+	}
+
+	best := -1
+	var override Generation
+	for prefix, o := range g.Overrides {
+		if strings.HasPrefix(model, prefix) && len(prefix) > best {
+			best = len(prefix)
+			override = o
+		}
+	}
+	if best < 0 {
+		return resolved
+	}
+	override.Overrides = nil
+	return override.over(resolved)
+}
+
+// over returns g with every unset field taken from base.
+func (g Generation) over(base Generation) Generation {
+	merged := g
+	if merged.Model == "" {
+		merged.Model = base.Model
+	}
+	if merged.Temperature == nil {
+		merged.Temperature = base.Temperature
+	}
+	if merged.TopP == nil {
+		merged.TopP = base.TopP
+	}
+	if merged.MaxTokens == 0 {
+		merged.MaxTokens = base.MaxTokens
+	}
+	if merged.Stop == nil {
+		merged.Stop = slices.Clone(base.Stop)
+	}
+	if merged.Seed == nil {
+		merged.Seed = base.Seed
+	}
+	if merged.ResponseFormat == "" {
+		merged.ResponseFormat = base.ResponseFormat
+	}
+
+	if len(base.Overrides) > 0 {
+		merged.Overrides = make(map[string]Generation, len(base.Overrides)+len(g.Overrides))
+		for prefix, o := range base.Overrides {
+			merged.Overrides[prefix] = o
+		}
+		for prefix, o := range g.Overrides {
+			if parent, ok := base.Overrides[prefix]; ok {
+				o = o.over(parent)
+			}
+			merged.Overrides[prefix] = o
+		}
+	}
+	return merged
+}
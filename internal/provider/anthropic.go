@@ -0,0 +1,303 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/devaloi/promptkit/internal/config"
+	"github.com/devaloi/promptkit/internal/engine"
+)
+
+// AnthropicConfig configures an Anthropic provider. Empty fields fall back
+// to the environment; see NewAnthropic.
+type AnthropicConfig struct {
+	// BaseURL is the API root without its version, such as
+	// "https://api.anthropic.com".
+	BaseURL string
+
+	APIKey string
+
+	// Model is used for requests whose template names no model, or a model
+	// of another vendor such as a gpt-4o model_hint. Without it such
+	// requests fail before anything is sent.
+	Model string
+
+	// MaxTokens is sent when the template sets no max_tokens; the Messages
+	// API requires one. It defaults to 1024.
+	MaxTokens int
+
+	// Stream requests the response as server-sent events, calling OnText
+	// with each piece of text as it arrives.
+	Stream bool
+	OnText func(text string)
+
+	// HTTPClient sends the requests; http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// Anthropic is a Provider for the Anthropic Messages API. System messages
+// are sent as the top-level system prompt. The seed and response_format
+// generation settings have no Messages API equivalent and are not sent, so
+// JSON responses must be asked for in the prompt, for example with the
+// json_schema include.
+type Anthropic struct {
+	cfg AnthropicConfig
+}
+
+// NewAnthropic creates an Anthropic provider. An empty BaseURL is read from
+// ANTHROPIC_BASE_URL, defaulting to the Anthropic API, and an empty APIKey
+// from ANTHROPIC_API_KEY.
+func NewAnthropic(cfg AnthropicConfig) *Anthropic {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = os.Getenv(config.EnvAnthropicBaseURL)
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = config.DefaultAnthropicBaseURL
+	}
+	if cfg.APIKey == "" {
+		cfg.APIKey = os.Getenv(config.EnvAnthropicKey)
+	}
+	if cfg.MaxTokens == 0 {
+		cfg.MaxTokens = config.DefaultAnthropicMaxTokens
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	return &Anthropic{cfg: cfg}
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicResponse struct {
+	Model      string           `json:"model"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      anthropicUsage   `json:"usage"`
+}
+
+// Complete sends req to the Messages API.
+func (p *Anthropic) Complete(ctx context.Context, req Request) (Response, error) {
+	body, err := p.request(req)
+	if err != nil {
+		return Response{}, err
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return Response{}, fmt.Errorf("anthropic: encoding request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.BaseURL+"/v1/messages", bytes.NewReader(data))
+	if err != nil {
+		return Response{}, fmt.Errorf("anthropic: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("anthropic-version", config.AnthropicVersion)
+	if p.cfg.APIKey != "" {
+		httpReq.Header.Set("x-api-key", p.cfg.APIKey)
+	}
+
+	httpResp, err := p.cfg.HTTPClient.Do(httpReq)
+	if err != nil {
+		return Response{}, fmt.Errorf("anthropic: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode/100 != 2 {
+		return Response{}, readAPIError("anthropic", httpResp)
+	}
+	if p.cfg.Stream {
+		return p.readStream(httpResp.Body)
+	}
+
+	var resp anthropicResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return Response{}, fmt.Errorf("anthropic: decoding response: %w", err)
+	}
+	var text strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return Response{
+		Content:    text.String(),
+		Model:      resp.Model,
+		StopReason: resp.StopReason,
+		Usage:      Usage(resp.Usage),
+	}, nil
+}
+
+// request maps req onto a Messages API request body. System messages are
+// joined into the system prompt and consecutive messages of the same role
+// are merged into one message with several text blocks.
+func (p *Anthropic) request(req Request) (anthropicRequest, error) {
+	model := req.Params.Model
+	switch {
+	case strings.HasPrefix(model, config.AnthropicModelPrefix):
+	case p.cfg.Model != "":
+		model = p.cfg.Model
+	case model == "":
+		return anthropicRequest{}, errors.New("anthropic: no model given by the template or the provider")
+	default:
+		return anthropicRequest{}, fmt.Errorf("anthropic: template model %q is not an Anthropic model; configure a %s model for the provider", model, config.AnthropicModelPrefix)
+	}
+	params := req.ParamsFor(model)
+
+	body := anthropicRequest{
+		Model:         params.Model,
+		MaxTokens:     params.MaxTokens,
+		Temperature:   params.Temperature,
+		TopP:          params.TopP,
+		StopSequences: params.Stop,
+		Stream:        p.cfg.Stream,
+	}
+	if body.MaxTokens == 0 {
+		body.MaxTokens = p.cfg.MaxTokens
+	}
+
+	var system []string
+	for _, m := range req.Messages {
+		if m.Role == engine.RoleSystem {
+			system = append(system, m.Content)
+			continue
+		}
+		block := anthropicBlock{Type: "text", Text: m.Content}
+		if n := len(body.Messages); n > 0 && body.Messages[n-1].Role == string(m.Role) {
+			body.Messages[n-1].Content = append(body.Messages[n-1].Content, block)
+			continue
+		}
+		body.Messages = append(body.Messages, anthropicMessage{Role: string(m.Role), Content: []anthropicBlock{block}})
+	}
+	body.System = strings.Join(system, "\n\n")
+	if len(body.Messages) == 0 {
+		return anthropicRequest{}, errors.New("anthropic: the prompt has no user message")
+	}
+	return body, nil
+}
+
+// anthropicEvent is the data of a Messages API stream event. Only the fields
+// promptkit uses are decoded.
+type anthropicEvent struct {
+	Type    string            `json:"type"`
+	Message anthropicResponse `json:"message"`
+	Delta   struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// readStream assembles a response from a stream of server-sent events.
+func (p *Anthropic) readStream(r io.Reader) (Response, error) {
+	var (
+		resp    Response
+		text    strings.Builder
+		stopped bool
+	)
+	err := readEvents(r, func(data []byte) error {
+		var ev anthropicEvent
+		if err := json.Unmarshal(data, &ev); err != nil {
+			return fmt.Errorf("anthropic: decoding stream event: %w", err)
+		}
+		switch ev.Type {
+		case "message_start":
+			resp.Model = ev.Message.Model
+			resp.Usage.InputTokens = ev.Message.Usage.InputTokens
+		case "content_block_delta":
+			if ev.Delta.Type == "text_delta" {
+				text.WriteString(ev.Delta.Text)
+				if p.cfg.OnText != nil {
+					p.cfg.OnText(ev.Delta.Text)
+				}
+			}
+		case "message_delta":
+			resp.StopReason = ev.Delta.StopReason
+			resp.Usage.OutputTokens = ev.Usage.OutputTokens
+		case "message_stop":
+			stopped = true
+		case "error":
+			return &APIError{Provider: "anthropic", Type: ev.Error.Type, Message: ev.Error.Message}
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	if !stopped {
+		return Response{}, errors.New("anthropic: stream ended before message_stop")
+	}
+	resp.Content = text.String()
+	return resp, nil
+}
+
+// readEvents calls fn with the data of every server-sent event read from r.
+// Multi-line data is joined with newlines; comments and event names are
+// skipped, since the data carries the event type.
+func readEvents(r io.Reader, fn func(data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+
+	var data []byte
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				if err := fn(data); err != nil {
+					return err
+				}
+				data = data[:0]
+			}
+		case strings.HasPrefix(line, "data:"):
+			if len(data) > 0 {
+				data = append(data, '\n')
+			}
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading event stream: %w", err)
+	}
+	if len(data) > 0 {
+		return fn(data)
+	}
+	return nil
+}
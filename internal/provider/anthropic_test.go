@@ -0,0 +1,254 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devaloi/promptkit/internal/engine"
+	"github.com/devaloi/promptkit/internal/frontmatter"
+)
+
+// fakeAnthropic is a Messages API server that replies with the text of the
+// last user message in upper case, streaming it in two deltas when asked,
+// and records every request.
+type fakeAnthropic struct {
+	*httptest.Server
+	requests []map[string]any
+	headers  []http.Header
+}
+
+func newFakeAnthropic(t *testing.T) *fakeAnthropic {
+	t.Helper()
+	f := &fakeAnthropic{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/messages" {
+			http.NotFound(w, r)
+			return
+		}
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.requests = append(f.requests, body)
+		f.headers = append(f.headers, r.Header.Clone())
+
+		if body["model"] == "overloaded" && body["stream"] != true {
+			w.WriteHeader(529)
+			w.Write([]byte(`{"type": "error", "error": {"type": "overloaded_error", "message": "Overloaded"}}`))
+			return
+		}
+
+		messages := body["messages"].([]any)
+		blocks := messages[len(messages)-1].(map[string]any)["content"].([]any)
+		reply := strings.ToUpper(blocks[len(blocks)-1].(map[string]any)["text"].(string))
+
+		if body["stream"] != true {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"model": %q, "content": [{"type": "text", "text": %q}], "stop_reason": "end_turn", "usage": {"input_tokens": 9, "output_tokens": 2}}`,
+				body["model"], reply)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		half := len(reply) / 2
+		fmt.Fprintf(w, "event: message_start\ndata: {\"type\": \"message_start\", \"message\": {\"model\": %q, \"usage\": {\"input_tokens\": 9, \"output_tokens\": 1}}}\n\n", body["model"])
+		fmt.Fprint(w, ": keep-alive\n\nevent: ping\ndata: {\"type\": \"ping\"}\n\n")
+		fmt.Fprint(w, "event: content_block_start\ndata: {\"type\": \"content_block_start\", \"index\": 0, \"content_block\": {\"type\": \"text\", \"text\": \"\"}}\n\n")
+		fmt.Fprintf(w, "event: content_block_delta\ndata: {\"type\": \"content_block_delta\", \"index\": 0, \"delta\": {\"type\": \"text_delta\", \"text\": %q}}\n\n", reply[:half])
+		if body["model"] == "overloaded" {
+			fmt.Fprint(w, "event: error\ndata: {\"type\": \"error\", \"error\": {\"type\": \"overloaded_error\", \"message\": \"Overloaded\"}}\n\n")
+			return
+		}
+		fmt.Fprintf(w, "event: content_block_delta\ndata: {\"type\": \"content_block_delta\", \"index\": 0, \"delta\": {\"type\": \"text_delta\", \"text\": %q}}\n\n", reply[half:])
+		fmt.Fprint(w, "event: content_block_stop\ndata: {\"type\": \"content_block_stop\", \"index\": 0}\n\n")
+		fmt.Fprint(w, "event: message_delta\ndata: {\"type\": \"message_delta\", \"delta\": {\"stop_reason\": \"stop_sequence\"}, \"usage\": {\"output_tokens\": 4}}\n\n")
+		if body["model"] != "truncated" {
+			fmt.Fprint(w, "event: message_stop\ndata: {\"type\": \"message_stop\"}\n\n")
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func anthropicMessages() []engine.Message {
+	return []engine.Message{
+		{Role: engine.RoleSystem, Content: "Be brief."},
+		{Role: engine.RoleUser, Content: "Context."},
+		{Role: engine.RoleUser, Content: "hello"},
+		{Role: engine.RoleSystem, Content: "No markdown."},
+	}
+}
+
+func TestAnthropic_Complete(t *testing.T) {
+	server := newFakeAnthropic(t)
+	p := NewAnthropic(AnthropicConfig{BaseURL: server.URL + "/", APIKey: "sk-ant-test"})
+
+	temperature := 0.5
+	resp, err := p.Complete(context.Background(), Request{
+		Messages: anthropicMessages(),
+		Params:   frontmatter.Generation{Model: "claude-sonnet", Temperature: &temperature, Stop: []string{"END"}, ResponseFormat: frontmatter.FormatJSONObject},
+	})
+	if err != nil {
+		t.Fatalf("Complete error: %v", err)
+	}
+	want := Response{Content: "HELLO", Model: "claude-sonnet", StopReason: "end_turn", Usage: Usage{InputTokens: 9, OutputTokens: 2}}
+	if resp != want {
+		t.Errorf("Complete = %+v, want %+v", resp, want)
+	}
+
+	h := server.headers[0]
+	if h.Get("x-api-key") != "sk-ant-test" || h.Get("anthropic-version") != "2023-06-01" || h.Get("Authorization") != "" {
+		t.Errorf("unexpected headers: %v", h)
+	}
+	body := server.requests[0]
+	if body["system"] != "Be brief.\n\nNo markdown." || body["max_tokens"] != 1024.0 || body["temperature"] != 0.5 {
+		t.Errorf("unexpected request: %v", body)
+	}
+	if stop, _ := json.Marshal(body["stop_sequences"]); string(stop) != `["END"]` {
+		t.Errorf("unexpected stop_sequences: %s", stop)
+	}
+	for _, key := range []string{"stream", "top_p", "response_format", "seed"} {
+		if _, ok := body[key]; ok {
+			t.Errorf("unexpected %s in request: %v", key, body)
+		}
+	}
+	messages, _ := json.Marshal(body["messages"])
+	if string(messages) != `[{"content":[{"text":"Context.","type":"text"},{"text":"hello","type":"text"}],"role":"user"}]` {
+		t.Errorf("unexpected messages: %s", messages)
+	}
+}
+
+func TestAnthropic_ConfiguredModel(t *testing.T) {
+	server := newFakeAnthropic(t)
+	p := NewAnthropic(AnthropicConfig{BaseURL: server.URL, Model: "claude-haiku"})
+
+	for _, model := range []string{"", "gpt-4o", "claude-opus"} {
+		_, err := p.Complete(context.Background(), Request{Messages: anthropicMessages(), Params: frontmatter.Generation{Model: model}})
+		if err != nil {
+			t.Fatalf("Complete(%q) error: %v", model, err)
+		}
+	}
+	var sent []string
+	for _, body := range server.requests {
+		sent = append(sent, body["model"].(string))
+	}
+	if got := strings.Join(sent, ","); got != "claude-haiku,claude-haiku,claude-opus" {
+		t.Errorf("unexpected models sent: %s", got)
+	}
+}
+
+func TestAnthropic_ConfiguredModelOverrides(t *testing.T) {
+	server := newFakeAnthropic(t)
+	p := NewAnthropic(AnthropicConfig{BaseURL: server.URL, Model: "claude-haiku"})
+
+	low, high := 0.2, 0.3
+	gen := frontmatter.Generation{
+		Model:       "gpt-4o",
+		Temperature: &high,
+		MaxTokens:   100,
+		Overrides:   map[string]frontmatter.Generation{"claude": {Temperature: &low}},
+	}
+	req := Request{Messages: anthropicMessages(), Params: gen.For("gpt-4o"), Generation: &gen}
+	if _, err := p.Complete(context.Background(), req); err != nil {
+		t.Fatalf("Complete error: %v", err)
+	}
+	body := server.requests[0]
+	if body["model"] != "claude-haiku" || body["temperature"] != 0.2 || body["max_tokens"] != 100.0 {
+		t.Errorf("unexpected request: %v", body)
+	}
+}
+
+func TestAnthropic_Stream(t *testing.T) {
+	server := newFakeAnthropic(t)
+	var deltas []string
+	p := NewAnthropic(AnthropicConfig{
+		BaseURL:   server.URL,
+		Model:     "claude-haiku",
+		MaxTokens: 300,
+		Stream:    true,
+		OnText:    func(text string) { deltas = append(deltas, text) },
+	})
+
+	resp, err := p.Complete(context.Background(), Request{Messages: anthropicMessages()})
+	if err != nil {
+		t.Fatalf("Complete error: %v", err)
+	}
+	want := Response{Content: "HELLO", Model: "claude-haiku", StopReason: "stop_sequence", Usage: Usage{InputTokens: 9, OutputTokens: 4}}
+	if resp != want {
+		t.Errorf("Complete = %+v, want %+v", resp, want)
+	}
+	if strings.Join(deltas, "|") != "HE|LLO" {
+		t.Errorf("unexpected deltas: %q", deltas)
+	}
+	if body := server.requests[0]; body["stream"] != true || body["max_tokens"] != 300.0 || body["model"] != "claude-haiku" {
+		t.Errorf("unexpected request: %v", body)
+	}
+}
+
+func TestAnthropic_Errors(t *testing.T) {
+	server := newFakeAnthropic(t)
+	req := Request{Messages: anthropicMessages()}
+
+	_, err := NewAnthropic(AnthropicConfig{BaseURL: server.URL, Model: "overloaded"}).Complete(context.Background(), req)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 529 || apiErr.Type != "overloaded_error" {
+		t.Errorf("expected an overloaded *APIError, got %v", err)
+	}
+
+	_, err = NewAnthropic(AnthropicConfig{BaseURL: server.URL, Model: "overloaded", Stream: true}).Complete(context.Background(), req)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 0 || err.Error() != "anthropic: overloaded_error: Overloaded" {
+		t.Errorf("expected a stream *APIError, got %v", err)
+	}
+
+	_, err = NewAnthropic(AnthropicConfig{BaseURL: server.URL, Model: "truncated", Stream: true}).Complete(context.Background(), req)
+	if err == nil || !strings.Contains(err.Error(), "before message_stop") {
+		t.Errorf("expected a truncated stream error, got %v", err)
+	}
+
+	p := NewAnthropic(AnthropicConfig{BaseURL: server.URL})
+	if _, err := p.Complete(context.Background(), req); err == nil || !strings.Contains(err.Error(), "no model") {
+		t.Errorf("expected a no model error, got %v", err)
+	}
+	gpt := Request{Messages: anthropicMessages(), Params: frontmatter.Generation{Model: "gpt-4o"}}
+	if _, err := p.Complete(context.Background(), gpt); err == nil || !strings.Contains(err.Error(), `"gpt-4o" is not an Anthropic model`) {
+		t.Errorf("expected a foreign model error, got %v", err)
+	}
+	systemOnly := Request{Messages: []engine.Message{{Role: engine.RoleSystem, Content: "x"}}, Params: frontmatter.Generation{Model: "claude"}}
+	if _, err := p.Complete(context.Background(), systemOnly); err == nil || !strings.Contains(err.Error(), "no user message") {
+		t.Errorf("expected a no user message error, got %v", err)
+	}
+	if len(server.requests) != 3 {
+		t.Errorf("invalid requests reached the server: %d requests", len(server.requests))
+	}
+}
+
+func TestNewAnthropic_Env(t *testing.T) {
+	t.Setenv("ANTHROPIC_BASE_URL", "")
+	t.Setenv("ANTHROPIC_API_KEY", "from-env")
+
+	p := NewAnthropic(AnthropicConfig{})
+	if p.cfg.BaseURL != "https://api.anthropic.com" || p.cfg.APIKey != "from-env" || p.cfg.MaxTokens != 1024 {
+		t.Errorf("unexpected config: %+v", p.cfg)
+	}
+}
+
+func TestReadEvents(t *testing.T) {
+	stream := ": comment\nevent: a\ndata: one\ndata: two\n\nid: 7\ndata:three\n\n\ndata: last"
+	var got []string
+	if err := readEvents(strings.NewReader(stream), func(data []byte) error {
+		got = append(got, string(data))
+		return nil
+	}); err != nil {
+		t.Fatalf("readEvents error: %v", err)
+	}
+	if strings.Join(got, "|") != "one\ntwo|three|last" {
+		t.Errorf("unexpected events: %q", got)
+	}
+}
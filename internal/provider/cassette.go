@@ -0,0 +1,168 @@
+package provider
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/devaloi/promptkit/internal/engine"
+	"github.com/devaloi/promptkit/internal/frontmatter"
+)
+
+// cassetteVersion is the format version written to cassette files.
+const cassetteVersion = 1
+
+// ErrUnrecorded is returned by a Replayer for a request its cassette does not
+// contain.
+var ErrUnrecorded = errors.New("request not recorded in cassette")
+
+// Interaction is a recorded request and the response it received.
+type Interaction struct {
+	// Key identifies the request; see Key.
+	Key string `json:"key"`
+
+	Template string                 `json:"template"`
+	Messages []engine.Message       `json:"messages"`
+	Params   frontmatter.Generation `json:"params"`
+	Response Response               `json:"response"`
+}
+
+// cassette is the JSON file format of recorded interactions.
+type cassette struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Key returns the cassette key of req: a hash of its template, messages and
+// generation settings.
+func Key(req Request) string {
+	data, _ := json.Marshal(struct {
+		Template string                 `json:"template"`
+		Messages []engine.Message       `json:"messages"`
+		Params   frontmatter.Generation `json:"params"`
+	}{req.Template, req.Messages, req.Params})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Recorder is a Provider that passes requests to another provider and
+// records every request and response to a cassette file, which a Replayer
+// can serve later.
+type Recorder struct {
+	provider Provider
+	path     string
+
+	mu       sync.Mutex
+	recorded cassette
+}
+
+// NewRecorder creates a Recorder that records the interactions of p to the
+// cassette file at path, replacing any existing cassette.
+func NewRecorder(p Provider, path string) *Recorder {
+	return &Recorder{provider: p, path: path, recorded: cassette{Version: cassetteVersion}}
+}
+
+// Complete sends req to the wrapped provider and writes the response to the
+// cassette before returning it. Failed requests are not recorded.
+func (r *Recorder) Complete(ctx context.Context, req Request) (Response, error) {
+	resp, err := r.provider.Complete(ctx, req)
+	if err != nil {
+		return Response{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	interaction := Interaction{Key: Key(req), Template: req.Template, Messages: req.Messages, Params: req.Params, Response: resp}
+	replaced := false
+	for i, in := range r.recorded.Interactions {
+		if in.Key == interaction.Key {
+			r.recorded.Interactions[i] = interaction
+			replaced = true
+		}
+	}
+	if !replaced {
+		r.recorded.Interactions = append(r.recorded.Interactions, interaction)
+	}
+	// Requests may arrive in any order, for example from chain steps running
+	// concurrently, so sort them to keep the file stable.
+	slices.SortFunc(r.recorded.Interactions, func(a, b Interaction) int {
+		return cmp.Or(strings.Compare(a.Template, b.Template), strings.Compare(a.Key, b.Key))
+	})
+	if err := writeCassette(r.path, r.recorded); err != nil {
+		return Response{}, err
+	}
+	return resp, nil
+}
+
+// writeCassette replaces the cassette file at path with c.
+func writeCassette(path string, c cassette) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding cassette: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("writing cassette: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing cassette: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing cassette: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing cassette: %w", err)
+	}
+	return nil
+}
+
+// Replayer is a Provider that serves responses from a cassette file written
+// by a Recorder, without calling a model.
+type Replayer struct {
+	path      string
+	responses map[string]Response
+}
+
+// NewReplayer reads the cassette file at path.
+func NewReplayer(path string) (*Replayer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading cassette: %w", err)
+	}
+	var c cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parsing cassette %s: %w", path, err)
+	}
+	if c.Version != cassetteVersion {
+		return nil, fmt.Errorf("cassette %s has unsupported version %d", path, c.Version)
+	}
+
+	responses := make(map[string]Response, len(c.Interactions))
+	for _, in := range c.Interactions {
+		responses[in.Key] = in.Response
+	}
+	return &Replayer{path: path, responses: responses}, nil
+}
+
+// Complete returns the recorded response to req. A request that was not
+// recorded, including one whose prompt or settings have changed since,
+// fails with an error wrapping ErrUnrecorded.
+func (r *Replayer) Complete(_ context.Context, req Request) (Response, error) {
+	key := Key(req)
+	resp, ok := r.responses[key]
+	if !ok {
+		return Response{}, fmt.Errorf("%w %s: template %q, key %s; record the cassette again", ErrUnrecorded, r.path, req.Template, key)
+	}
+	return resp, nil
+}
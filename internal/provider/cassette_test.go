@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/devaloi/promptkit/internal/engine"
+	"github.com/devaloi/promptkit/internal/frontmatter"
+)
+
+func cassetteRequest(template, content string) Request {
+	return Request{
+		Template: template,
+		Messages: []engine.Message{{Role: engine.RoleUser, Content: content}},
+		Params:   frontmatter.Generation{Model: "gpt-4o", MaxTokens: 100},
+	}
+}
+
+func TestRecorder_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.cassette.json")
+
+	calls := 0
+	model := Func(func(_ context.Context, req Request) (Response, error) {
+		calls++
+		if req.Template == "broken" {
+			return Response{}, errors.New("boom")
+		}
+		return Response{Content: strings.ToUpper(req.Messages[0].Content), Model: "gpt-4o-2024", Usage: Usage{InputTokens: 5, OutputTokens: 1}}, nil
+	})
+
+	rec := NewRecorder(model, path)
+	for _, req := range []Request{
+		cassetteRequest("summarize", "first"),
+		cassetteRequest("classify", "second"),
+		cassetteRequest("summarize", "first"),
+	} {
+		if _, err := rec.Complete(context.Background(), req); err != nil {
+			t.Fatalf("Complete error: %v", err)
+		}
+	}
+	if _, err := rec.Complete(context.Background(), cassetteRequest("broken", "x")); err == nil {
+		t.Fatal("expected the provider error")
+	}
+	if calls != 4 {
+		t.Errorf("expected every request to reach the provider, got %d calls", calls)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading cassette: %v", err)
+	}
+	var file struct {
+		Version      int
+		Interactions []Interaction
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("parsing cassette: %v", err)
+	}
+	if file.Version != 1 || len(file.Interactions) != 2 || file.Interactions[0].Template != "classify" {
+		t.Errorf("unexpected cassette: %s", data)
+	}
+
+	replay, err := NewReplayer(path)
+	if err != nil {
+		t.Fatalf("NewReplayer error: %v", err)
+	}
+	resp, err := replay.Complete(context.Background(), cassetteRequest("classify", "second"))
+	if err != nil {
+		t.Fatalf("replay error: %v", err)
+	}
+	if resp.Content != "SECOND" || resp.Model != "gpt-4o-2024" || resp.Usage.InputTokens != 5 {
+		t.Errorf("unexpected replayed response: %+v", resp)
+	}
+	if calls != 4 {
+		t.Errorf("replay called the provider")
+	}
+
+	changed := cassetteRequest("classify", "second")
+	changed.Params.MaxTokens = 200
+	for _, req := range []Request{changed, cassetteRequest("classify", "other"), cassetteRequest("broken", "x")} {
+		_, err := replay.Complete(context.Background(), req)
+		if !errors.Is(err, ErrUnrecorded) || !strings.Contains(err.Error(), req.Template) {
+			t.Errorf("expected ErrUnrecorded naming %s, got %v", req.Template, err)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	base := cassetteRequest("summarize", "text")
+	if Key(base) != Key(cassetteRequest("summarize", "text")) {
+		t.Error("equal requests have different keys")
+	}
+
+	withSchema := base
+	withSchema.OutputSchema = &frontmatter.Schema{Type: "object"}
+	if Key(withSchema) != Key(base) {
+		t.Error("output_schema changed the key")
+	}
+
+	temperature := 0.0
+	variants := []Request{cassetteRequest("summarize@1.0.0", "text"), cassetteRequest("summarize", "text!")}
+	v := cassetteRequest("summarize", "text")
+	v.Params.Temperature = &temperature
+	variants = append(variants, v)
+	v = cassetteRequest("summarize", "text")
+	v.Messages[0].Role = engine.RoleSystem
+	variants = append(variants, v)
+	for i, req := range variants {
+		if Key(req) == Key(base) {
+			t.Errorf("variant %d has the same key", i)
+		}
+	}
+}
+
+func TestNewReplayer_Errors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewReplayer(filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist, got %v", err)
+	}
+
+	path := filepath.Join(dir, "future.json")
+	if err := os.WriteFile(path, []byte(`{"version": 2, "interactions": []}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewReplayer(path); err == nil || !strings.Contains(err.Error(), "unsupported version 2") {
+		t.Errorf("expected a version error, got %v", err)
+	}
+}
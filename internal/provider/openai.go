@@ -0,0 +1,209 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/devaloi/promptkit/internal/config"
+	"github.com/devaloi/promptkit/internal/frontmatter"
+)
+
+// OpenAIConfig configures an OpenAI provider. Empty fields fall back to the
+// environment; see NewOpenAI.
+type OpenAIConfig struct {
+	// BaseURL is the API root including its version, such as
+	// "http://localhost:8000/v1" for a local server.
+	BaseURL string
+
+	// APIKey is sent as a bearer token. Local servers usually need none.
+	APIKey string
+
+	// Model is used for requests whose template names no model.
+	Model string
+
+	// HTTPClient sends the requests; http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// OpenAI is a Provider for the OpenAI chat completions API and servers
+// compatible with it, such as vLLM, llama.cpp server, LM Studio and Ollama.
+type OpenAI struct {
+	cfg OpenAIConfig
+}
+
+// NewOpenAI creates an OpenAI provider. An empty BaseURL is read from
+// OPENAI_BASE_URL, defaulting to the OpenAI API, and an empty APIKey from
+// OPENAI_API_KEY.
+func NewOpenAI(cfg OpenAIConfig) *OpenAI {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = os.Getenv(config.EnvOpenAIBaseURL)
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = config.DefaultOpenAIBaseURL
+	}
+	if cfg.APIKey == "" {
+		cfg.APIKey = os.Getenv(config.EnvOpenAIKey)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	return &OpenAI{cfg: cfg}
+}
+
+type openAIRequest struct {
+	Model          string                `json:"model"`
+	Messages       []openAIMessage       `json:"messages"`
+	Temperature    *float64              `json:"temperature,omitempty"`
+	TopP           *float64              `json:"top_p,omitempty"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	Stop           []string              `json:"stop,omitempty"`
+	Seed           *int                  `json:"seed,omitempty"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *openAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+type openAIJSONSchema struct {
+	Name   string              `json:"name"`
+	Schema *frontmatter.Schema `json:"schema"`
+}
+
+type openAIResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      openAIMessage `json:"message"`
+		FinishReason string        `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// Complete sends req to the chat completions endpoint.
+func (p *OpenAI) Complete(ctx context.Context, req Request) (Response, error) {
+	body, err := p.request(req)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var resp openAIResponse
+	if err := p.post(ctx, body, &resp); err != nil {
+		return Response{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return Response{}, errors.New("openai: response has no choices")
+	}
+	return Response{
+		Content:    resp.Choices[0].Message.Content,
+		Model:      resp.Model,
+		StopReason: resp.Choices[0].FinishReason,
+		Usage: Usage{
+			InputTokens:  resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.CompletionTokens,
+		},
+	}, nil
+}
+
+// request maps req onto a chat completions request body.
+func (p *OpenAI) request(req Request) (openAIRequest, error) {
+	model := req.Params.Model
+	if model == "" {
+		model = p.cfg.Model
+	}
+	if model == "" {
+		return openAIRequest{}, errors.New("openai: no model given by the template or the provider")
+	}
+	params := req.ParamsFor(model)
+
+	body := openAIRequest{
+		Model:       params.Model,
+		Messages:    make([]openAIMessage, len(req.Messages)),
+		Temperature: params.Temperature,
+		TopP:        params.TopP,
+		MaxTokens:   params.MaxTokens,
+		Stop:        params.Stop,
+		Seed:        params.Seed,
+	}
+	for i, m := range req.Messages {
+		body.Messages[i] = openAIMessage{Role: string(m.Role), Content: m.Content}
+	}
+
+	switch params.ResponseFormat {
+	case "", frontmatter.FormatText:
+	case frontmatter.FormatJSONObject:
+		body.ResponseFormat = &openAIResponseFormat{Type: frontmatter.FormatJSONObject}
+	case frontmatter.FormatJSONSchema:
+		if req.OutputSchema == nil {
+			return openAIRequest{}, fmt.Errorf("openai: response_format %s needs an output_schema", frontmatter.FormatJSONSchema)
+		}
+		body.ResponseFormat = &openAIResponseFormat{
+			Type:       frontmatter.FormatJSONSchema,
+			JSONSchema: &openAIJSONSchema{Name: schemaName(req.Template), Schema: req.OutputSchema},
+		}
+	default:
+		return openAIRequest{}, fmt.Errorf("openai: unknown response_format %q", params.ResponseFormat)
+	}
+	return body, nil
+}
+
+// post sends body to the chat completions endpoint and decodes the reply
+// into out.
+func (p *OpenAI) post(ctx context.Context, body openAIRequest, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("openai: encoding request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.BaseURL+"/chat/completions", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("openai: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.cfg.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.cfg.APIKey)
+	}
+
+	httpResp, err := p.cfg.HTTPClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("openai: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode/100 != 2 {
+		return readAPIError("openai", httpResp)
+	}
+	if err := json.NewDecoder(httpResp.Body).Decode(out); err != nil {
+		return fmt.Errorf("openai: decoding response: %w", err)
+	}
+	return nil
+}
+
+// invalidSchemaName matches the characters not allowed in a response format
+// schema name.
+var invalidSchemaName = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// schemaName derives a response format schema name from a template
+// reference.
+func schemaName(template string) string {
+	name := strings.Trim(invalidSchemaName.ReplaceAllString(template, "_"), "_")
+	if name == "" {
+		return "response"
+	}
+	return name[:min(len(name), 64)]
+}
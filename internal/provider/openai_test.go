@@ -0,0 +1,190 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devaloi/promptkit/internal/engine"
+	"github.com/devaloi/promptkit/internal/frontmatter"
+)
+
+// fakeOpenAI is an OpenAI-compatible chat completions server that replies
+// with the last user message reversed and records every request.
+type fakeOpenAI struct {
+	*httptest.Server
+	requests []map[string]any
+	headers  []http.Header
+}
+
+func newFakeOpenAI(t *testing.T) *fakeOpenAI {
+	t.Helper()
+	f := &fakeOpenAI{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.requests = append(f.requests, body)
+		f.headers = append(f.headers, r.Header.Clone())
+
+		if body["model"] == "missing-model" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "The model does not exist", "type": "invalid_request_error"}}`))
+			return
+		}
+
+		messages := body["messages"].([]any)
+		last := messages[len(messages)-1].(map[string]any)["content"].(string)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"model": body["model"],
+			"choices": []any{map[string]any{
+				"message":       map[string]any{"role": "assistant", "content": reverse(last)},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]any{"prompt_tokens": 12, "completion_tokens": 3},
+		})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+func TestOpenAI_Complete(t *testing.T) {
+	server := newFakeOpenAI(t)
+	p := NewOpenAI(OpenAIConfig{BaseURL: server.URL + "/v1/", APIKey: "sk-test"})
+
+	temperature := 0.2
+	resp, err := p.Complete(context.Background(), Request{
+		Template: "summarize",
+		Messages: []engine.Message{
+			{Role: engine.RoleSystem, Content: "Be brief."},
+			{Role: engine.RoleUser, Content: "hello"},
+		},
+		Params: frontmatter.Generation{Model: "gpt-4o", Temperature: &temperature, MaxTokens: 50, Stop: []string{"END"}},
+	})
+	if err != nil {
+		t.Fatalf("Complete error: %v", err)
+	}
+	want := Response{Content: "olleh", Model: "gpt-4o", StopReason: "stop", Usage: Usage{InputTokens: 12, OutputTokens: 3}}
+	if resp.Content != want.Content || resp.Model != want.Model || resp.StopReason != want.StopReason || resp.Usage != want.Usage {
+		t.Errorf("Complete = %+v, want %+v", resp, want)
+	}
+
+	if got := server.headers[0].Get("Authorization"); got != "Bearer sk-test" {
+		t.Errorf("unexpected Authorization header %q", got)
+	}
+	body := server.requests[0]
+	if body["model"] != "gpt-4o" || body["temperature"] != 0.2 || body["max_tokens"] != 50.0 {
+		t.Errorf("unexpected request: %v", body)
+	}
+	if _, ok := body["top_p"]; ok {
+		t.Errorf("unset top_p was sent: %v", body)
+	}
+	if _, ok := body["response_format"]; ok {
+		t.Errorf("unset response_format was sent: %v", body)
+	}
+	messages, _ := json.Marshal(body["messages"])
+	if string(messages) != `[{"content":"Be brief.","role":"system"},{"content":"hello","role":"user"}]` {
+		t.Errorf("unexpected messages: %s", messages)
+	}
+}
+
+func TestOpenAI_ResponseFormat(t *testing.T) {
+	server := newFakeOpenAI(t)
+	p := NewOpenAI(OpenAIConfig{BaseURL: server.URL + "/v1", Model: "local"})
+
+	schema := &frontmatter.Schema{Type: "object", Required: []string{"label"}}
+	req := Request{
+		Template:     "support/classify@1.0.0",
+		Messages:     []engine.Message{{Role: engine.RoleUser, Content: "hi"}},
+		Params:       frontmatter.Generation{ResponseFormat: frontmatter.FormatJSONSchema},
+		OutputSchema: schema,
+	}
+	if _, err := p.Complete(context.Background(), req); err != nil {
+		t.Fatalf("Complete error: %v", err)
+	}
+
+	body := server.requests[0]
+	if body["model"] != "local" {
+		t.Errorf("expected the configured model, got %v", body["model"])
+	}
+	if _, ok := server.headers[0]["Authorization"]; ok {
+		t.Error("Authorization sent without an API key")
+	}
+	format, _ := json.Marshal(body["response_format"])
+	if string(format) != `{"json_schema":{"name":"support_classify_1_0_0","schema":{"required":["label"],"type":"object"}},"type":"json_schema"}` {
+		t.Errorf("unexpected response_format: %s", format)
+	}
+
+	req.OutputSchema = nil
+	if _, err := p.Complete(context.Background(), req); err == nil || !strings.Contains(err.Error(), "needs an output_schema") {
+		t.Errorf("expected an output_schema error, got %v", err)
+	}
+}
+
+func TestOpenAI_Errors(t *testing.T) {
+	server := newFakeOpenAI(t)
+	msgs := []engine.Message{{Role: engine.RoleUser, Content: "hi"}}
+
+	_, err := NewOpenAI(OpenAIConfig{BaseURL: server.URL + "/v1"}).Complete(context.Background(), Request{Messages: msgs})
+	if err == nil || !strings.Contains(err.Error(), "no model") {
+		t.Errorf("expected a no model error, got %v", err)
+	}
+
+	p := NewOpenAI(OpenAIConfig{BaseURL: server.URL + "/v1", Model: "missing-model"})
+	_, err = p.Complete(context.Background(), Request{Messages: msgs})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Type != "invalid_request_error" || apiErr.Message != "The model does not exist" {
+		t.Errorf("unexpected API error: %+v", apiErr)
+	}
+	if got := apiErr.Error(); got != "openai: 404 Not Found (invalid_request_error): The model does not exist" {
+		t.Errorf("unexpected message: %s", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p = NewOpenAI(OpenAIConfig{BaseURL: server.URL + "/v1", Model: "gpt-4o"})
+	if _, err := p.Complete(ctx, Request{Messages: msgs}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestNewOpenAI_Env(t *testing.T) {
+	t.Setenv("OPENAI_BASE_URL", "http://localhost:11434/v1/")
+	t.Setenv("OPENAI_API_KEY", "from-env")
+
+	p := NewOpenAI(OpenAIConfig{})
+	if p.cfg.BaseURL != "http://localhost:11434/v1" || p.cfg.APIKey != "from-env" {
+		t.Errorf("unexpected config: %+v", p.cfg)
+	}
+	p = NewOpenAI(OpenAIConfig{BaseURL: "http://other/v1", APIKey: "explicit"})
+	if p.cfg.BaseURL != "http://other/v1" || p.cfg.APIKey != "explicit" {
+		t.Errorf("explicit config was replaced: %+v", p.cfg)
+	}
+
+	t.Setenv("OPENAI_BASE_URL", "")
+	if p := NewOpenAI(OpenAIConfig{}); p.cfg.BaseURL != "https://api.openai.com/v1" {
+		t.Errorf("unexpected default base URL %q", p.cfg.BaseURL)
+	}
+}
@@ -0,0 +1,142 @@
+// Package provider defines the interface chains use to send rendered prompts
+// to language models.
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/devaloi/promptkit/internal/engine"
+	"github.com/devaloi/promptkit/internal/frontmatter"
+)
+
+// Provider completes chat prompts with a language model.
+type Provider interface {
+	// Complete sends the request's messages to the model and returns its
+	// reply. It must honour cancellation of ctx.
+	Complete(ctx context.Context, req Request) (Response, error)
+}
+
+// Func adapts an ordinary function to the Provider interface.
+type Func func(ctx context.Context, req Request) (Response, error)
+
+// Complete calls f(ctx, req).
+func (f Func) Complete(ctx context.Context, req Request) (Response, error) {
+	return f(ctx, req)
+}
+
+// Request is a rendered prompt ready to be sent to a model.
+type Request struct {
+	// Template is the reference of the template the prompt was rendered
+	// from, such as "summarize@1.2.0".
+	Template string
+
+	Messages []engine.Message
+
+	// Params holds the template's generation settings resolved for the
+	// model; Params.Model is empty when neither the template nor the caller
+	// names one, leaving the choice to the provider.
+	Params frontmatter.Generation
+
+	// Generation holds the template's generation settings with their
+	// overrides, before resolving them for a model. Providers that send the
+	// prompt to another model than Params.Model resolve it again; see
+	// ParamsFor.
+	Generation *frontmatter.Generation
+
+	// OutputSchema is the template's output_schema, for providers that can
+	// constrain responses when Params.ResponseFormat is json_schema.
+	OutputSchema *frontmatter.Schema
+}
+
+// ParamsFor returns the generation settings for sending r to model: Params
+// if model is Params.Model, and otherwise Generation resolved for model, so
+// the overrides for model apply. Without Generation it returns Params with
+// the model replaced.
+func (r Request) ParamsFor(model string) frontmatter.Generation {
+	switch {
+	case model == r.Params.Model:
+		return r.Params
+	case r.Generation != nil:
+		return r.Generation.For(model)
+	}
+	params := r.Params
+	params.Model = model
+	return params
+}
+
+// Response is a model's reply to a Request.
+type Response struct {
+	Content string `json:"content"`
+
+	// Model is the model that produced the response, as reported by the
+	// provider.
+	Model string `json:"model,omitempty"`
+
+	// StopReason is the provider's reason for ending the response, such as
+	// "stop" or "max_tokens".
+	StopReason string `json:"stop_reason,omitempty"`
+
+	Usage Usage `json:"usage"`
+}
+
+// Usage reports the tokens a request consumed.
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// APIError is an error response from a provider's API.
+type APIError struct {
+	Provider string
+
+	// StatusCode is the HTTP status, or 0 for an error reported in the
+	// middle of a streamed response.
+	StatusCode int
+
+	// Type is the provider's error type, such as "invalid_request_error",
+	// if it gave one.
+	Type    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	status := e.Type
+	if e.StatusCode != 0 {
+		status = fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+		if e.Type != "" {
+			status += " (" + e.Type + ")"
+		}
+	}
+	msg := e.Provider + ": " + status
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// readAPIError builds an APIError from an unsuccessful response. Both the
+// OpenAI and Anthropic APIs describe errors as {"error": {"type", "message"}};
+// other bodies are used as the message.
+func readAPIError(name string, resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	apiErr := &APIError{Provider: name, StatusCode: resp.StatusCode}
+
+	var body struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Error.Message != "" {
+		apiErr.Type = body.Error.Type
+		apiErr.Message = body.Error.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	return apiErr
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of problems reported in a Diagnostic.
+const (
+	KindUnreadable       = "unreadable"
+	KindFrontmatter      = "frontmatter"
+	KindDuplicateName    = "duplicate-name"
+	KindIncludeCollision = "include-collision"
+	KindCompile          = "compile"
+	KindExtends          = "extends"
+	KindVersion          = "version"
+)
+
+// Diagnostic describes a problem with a single file found while loading
+// templates. The file is skipped; a template that fails to compile is not
+// added to the registry.
+type Diagnostic struct {
+	Kind string
+
+	// Path is the file with the problem, relative to the root of the file
+	// system it was loaded from.
+	Path string
+
+	// Layer is the name of the layer Path belongs to, if it has one.
+	Layer string
+
+	// Line is the 1-based line of the problem in Path, or 0 if unknown.
+	Line int
+
+	// Name is the template or include name involved, if any.
+	Name string
+
+	// Other is the other file that declares the same name, for duplicate
+	// names, include collisions and unversioned templates of a versioned name.
+	Other string
+
+	Err error
+}
+
+func (d *Diagnostic) Error() string {
+	loc := d.Path
+	if d.Line > 0 {
+		loc = fmt.Sprintf("%s:%d", d.Path, d.Line)
+	}
+	if d.Layer != "" {
+		return fmt.Sprintf("layer %q: %s: %v", d.Layer, loc, d.Err)
+	}
+	return fmt.Sprintf("%s: %v", loc, d.Err)
+}
+
+func (d *Diagnostic) Unwrap() error {
+	return d.Err
+}
+
+// LoadError is returned when loading templates finds one or more problems.
+// Unless the registry is lenient, a load that fails this way leaves the
+// registry unchanged.
+type LoadError struct {
+	Diagnostics []*Diagnostic
+}
+
+func (e *LoadError) Error() string {
+	msgs := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		msgs[i] = d.Error()
+	}
+	return fmt.Sprintf("found %d problem(s): %s", len(e.Diagnostics), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the individual diagnostics.
+func (e *LoadError) Unwrap() []error {
+	errs := make([]error, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		errs[i] = d
+	}
+	return errs
+}
@@ -0,0 +1,131 @@
+package registry
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/devaloi/promptkit/internal/frontmatter"
+)
+
+func diagnosticsFS() fstest.MapFS {
+	return fstest.MapFS{
+		"includes/tone.tmpl":   {Data: []byte(`{{ define "voice" }}calm{{ end }}`)},
+		"includes/voice.tmpl":  {Data: []byte("loud")},
+		"includes/broken.tmpl": {Data: []byte("{{ if }}")},
+		"good.tmpl":            {Data: []byte(`{{ template "voice" }}`)},
+		"first.tmpl":           {Data: []byte("---\nname: shared\n---\nfirst")},
+		"second.tmpl":          {Data: []byte("---\nname: shared\n---\nsecond")},
+		"yaml.tmpl":            {Data: []byte("---\nname: yaml\nbad: : :\n---\nbody")},
+		"syntax.tmpl":          {Data: []byte("ok\n{{ .name ")},
+	}
+}
+
+func TestRegistry_LoadDiagnostics(t *testing.T) {
+	reg := New()
+	err := reg.LoadFS(diagnosticsFS(), ".")
+
+	var loadErr *LoadError
+	if !errors.As(err, &loadErr) {
+		t.Fatalf("expected *LoadError, got %v", err)
+	}
+	if len(reg.List()) != 0 {
+		t.Errorf("expected failed load to leave registry empty, got %d templates", len(reg.List()))
+	}
+
+	byKind := make(map[string]*Diagnostic)
+	for _, d := range loadErr.Diagnostics {
+		byKind[d.Kind] = d
+	}
+	if len(loadErr.Diagnostics) != 5 {
+		t.Fatalf("expected 5 diagnostics, got %d: %v", len(loadErr.Diagnostics), err)
+	}
+
+	if d := byKind[KindDuplicateName]; d == nil || d.Path != "second.tmpl" || d.Other != "first.tmpl" {
+		t.Errorf("unexpected duplicate diagnostic: %+v", d)
+	}
+	if d := byKind[KindIncludeCollision]; d == nil || d.Path != "includes/voice.tmpl" || d.Other != "includes/tone.tmpl" {
+		t.Errorf("unexpected collision diagnostic: %+v", d)
+	}
+	if d := byKind[KindFrontmatter]; d == nil || d.Path != "yaml.tmpl" || d.Line != 3 {
+		t.Errorf("unexpected frontmatter diagnostic: %+v", d)
+	}
+
+	var pe *frontmatter.ParseError
+	if !errors.As(err, &pe) {
+		t.Error("expected LoadError to unwrap to *frontmatter.ParseError")
+	}
+
+	var compile []string
+	for _, d := range loadErr.Diagnostics {
+		if d.Kind == KindCompile {
+			compile = append(compile, d.Path)
+		}
+	}
+	if strings.Join(compile, ",") != "includes/broken.tmpl,syntax.tmpl" {
+		t.Errorf("unexpected compile diagnostics: %v", compile)
+	}
+}
+
+func TestRegistry_CompileDiagnosticLines(t *testing.T) {
+	fsys := fstest.MapFS{
+		"includes/broken.tmpl": {Data: []byte("---\nname: broken\n---\n\n{{ if }}")},
+		"syntax.tmpl":          {Data: []byte("---\nname: syntax\n---\nok\n{{ end }}")},
+		"base.tmpl":            {Data: []byte("---\nname: base\n---\n{{ block \"body\" . }}{{ end }}")},
+		"child.tmpl":           {Data: []byte("---\nname: child\nextends: base\n---\n{{ define \"body\" }}\n{{ else }}{{ end }}")},
+	}
+
+	err := New().LoadFS(fsys, ".")
+	var loadErr *LoadError
+	if !errors.As(err, &loadErr) {
+		t.Fatalf("expected *LoadError, got %v", err)
+	}
+
+	lines := make(map[string]int)
+	for _, d := range loadErr.Diagnostics {
+		lines[d.Path] = d.Line
+	}
+	expected := map[string]int{"includes/broken.tmpl": 5, "syntax.tmpl": 5, "child.tmpl": 6}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %v", len(expected), err)
+	}
+	for path, line := range expected {
+		if lines[path] != line {
+			t.Errorf("%s: expected line %d, got %d", path, line, lines[path])
+		}
+	}
+}
+
+func TestRegistry_Lenient(t *testing.T) {
+	reg := NewWithOptions(Options{Lenient: true})
+	if err := reg.LoadFS(diagnosticsFS(), "."); err != nil {
+		t.Fatalf("LoadFS error: %v", err)
+	}
+
+	if len(reg.Diagnostics()) != 5 {
+		t.Errorf("expected 5 diagnostics, got %d", len(reg.Diagnostics()))
+	}
+
+	result, err := reg.Render("good", nil)
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if result.Output != "calm" {
+		t.Errorf("expected voice from the first include, got %q", result.Output)
+	}
+
+	result, err = reg.Render("shared", nil)
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if result.Output != "first" {
+		t.Errorf("expected first duplicate to win, got %q", result.Output)
+	}
+
+	for _, name := range []string{"yaml", "syntax"} {
+		if _, err := reg.Get(name); err == nil {
+			t.Errorf("expected %q to be skipped", name)
+		}
+	}
+}
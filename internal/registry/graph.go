@@ -0,0 +1,325 @@
+package registry
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/devaloi/promptkit/internal/config"
+	"github.com/devaloi/promptkit/internal/validator"
+)
+
+// Kinds of nodes in a Graph.
+const (
+	NodeTemplate = "template"
+	NodeInclude  = "include"
+	NodeChain    = "chain"
+)
+
+// Kinds of edges in a Graph.
+const (
+	// EdgeUses links a template or include to an include it renders.
+	EdgeUses = "uses"
+
+	// EdgeExtends links a template to the template it extends.
+	EdgeExtends = "extends"
+
+	// EdgeStep links a chain to a template rendered by one of its steps.
+	EdgeStep = "step"
+)
+
+// Node is a template, include or chain in a Graph.
+type Node struct {
+	Kind string
+
+	// Name is the chain name or the template's Ref, such as
+	// "summarize@1.2.0". Includes are named by their path relative to the
+	// load root without the extension, such as "support/includes/tone".
+	Name string
+
+	// Path is the file the node was loaded from, relative to the root of its
+	// layer; chains keep the path they were added with.
+	Path string
+
+	// Layer is the name of the layer the node was loaded from. Chains have
+	// no layer.
+	Layer string
+}
+
+// Edge links a node to a node it depends on.
+type Edge struct {
+	From *Node
+	To   *Node
+	Kind string
+}
+
+// Graph is the dependency graph of the templates and includes in a registry,
+// optionally extended with chains by AddChain.
+type Graph struct {
+	nodes map[nodeKey]*Node
+	edges map[Edge]bool
+
+	// set resolves the template references of chains.
+	set *set
+}
+
+type nodeKey struct {
+	kind, name string
+}
+
+// Graph returns the dependency graph of the loaded templates and includes.
+// The includes an include renders are resolved from the scope of each
+// template that reaches it, so an include shared by several directories
+// links to every include it may render there.
+func (r *Registry) Graph() *Graph {
+	s := r.current.Load()
+	g := &Graph{nodes: make(map[nodeKey]*Node), edges: make(map[Edge]bool), set: s}
+
+	for _, incs := range s.includes {
+		for _, inc := range incs {
+			g.add(&Node{Kind: NodeInclude, Name: includeNodeName(inc), Path: inc.Path, Layer: inc.Layer})
+		}
+	}
+	for _, tmpl := range s.templates {
+		g.add(&Node{Kind: NodeTemplate, Name: tmpl.Ref(), Path: tmpl.Path, Layer: tmpl.Layer})
+	}
+
+	for _, tmpl := range s.templates {
+		from := g.node(NodeTemplate, tmpl.Ref())
+		if tmpl.Meta.Extends != "" {
+			if parent, ok := s.resolve(tmpl.Meta.Extends, tmpl.scope); ok {
+				g.link(from, g.node(NodeTemplate, parent.Ref()), EdgeExtends)
+			}
+		}
+		g.linkUses(from, tmpl.Body, s.definers(tmpl.scope), make(map[*Include]bool))
+	}
+	return g
+}
+
+// includeNodeName returns the graph node name of inc.
+func includeNodeName(inc *Include) string {
+	return path.Join(inc.Scope, config.IncludesDir, inc.Name)
+}
+
+// definers maps every template name defined by the includes visible from
+// scope to the include defining it, with nearer includes shadowing those
+// further up like scoped.
+func (s *set) definers(scope string) map[string]*Include {
+	defs := make(map[string]*Include)
+	shadowed := make(map[string]bool)
+	for {
+		for name, inc := range s.includes[scope] {
+			if shadowed[name] {
+				continue
+			}
+			shadowed[name] = true
+			for _, def := range inc.defines {
+				if _, ok := defs[def]; !ok {
+					defs[def] = inc
+				}
+			}
+		}
+		if scope == "." {
+			return defs
+		}
+		scope = path.Dir(scope)
+	}
+}
+
+// linkUses links from to every include that body renders, and each of those
+// to the includes it renders in turn, resolving names with definers.
+func (g *Graph) linkUses(from *Node, body string, definers map[string]*Include, seen map[*Include]bool) {
+	templates, includes, err := validator.References(body)
+	if err != nil {
+		// Loaded templates and includes have already been parsed.
+		return
+	}
+	for _, name := range append(templates, includes...) {
+		inc, ok := definers[name]
+		if !ok {
+			continue
+		}
+		to := g.node(NodeInclude, includeNodeName(inc))
+		g.link(from, to, EdgeUses)
+		if !seen[inc] {
+			seen[inc] = true
+			g.linkUses(to, inc.Body, definers, seen)
+		}
+	}
+}
+
+func (g *Graph) add(n *Node) *Node {
+	g.nodes[nodeKey{n.Kind, n.Name}] = n
+	return n
+}
+
+func (g *Graph) node(kind, name string) *Node {
+	return g.nodes[nodeKey{kind, name}]
+}
+
+func (g *Graph) link(from, to *Node, kind string) {
+	if from != to {
+		g.edges[Edge{From: from, To: to, Kind: kind}] = true
+	}
+}
+
+// AddChain adds a chain loaded from file whose steps render the templates
+// refs refer to; see Registry.Get. A chain without a name is named after
+// file. References that match no template are ignored.
+func (g *Graph) AddChain(name, file string, refs []string) {
+	if name == "" {
+		name = file
+	}
+	from := g.add(&Node{Kind: NodeChain, Name: name, Path: file})
+	for _, ref := range refs {
+		if tmpl, err := g.set.lookup(ref); err == nil {
+			g.link(from, g.node(NodeTemplate, tmpl.Ref()), EdgeStep)
+		}
+	}
+}
+
+// Nodes returns the nodes of the graph sorted by kind and name.
+func (g *Graph) Nodes() []*Node {
+	nodes := make([]*Node, 0, len(g.nodes))
+	for _, n := range g.nodes {
+		nodes = append(nodes, n)
+	}
+	sortNodes(nodes)
+	return nodes
+}
+
+// Edges returns the edges of the graph sorted by their nodes.
+func (g *Graph) Edges() []Edge {
+	edges := make([]Edge, 0, len(g.edges))
+	for e := range g.edges {
+		edges = append(edges, e)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return nodeLess(edges[i].From, edges[j].From)
+		}
+		return nodeLess(edges[i].To, edges[j].To)
+	})
+	return edges
+}
+
+func sortNodes(nodes []*Node) {
+	sort.Slice(nodes, func(i, j int) bool { return nodeLess(nodes[i], nodes[j]) })
+}
+
+func nodeLess(a, b *Node) bool {
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	return a.Name < b.Name
+}
+
+// Find returns the nodes ref refers to: a node's name, a template name
+// without a version (matching every version), an include's name or file name
+// without its directory, or the file a node was loaded from. Files may be
+// given relative to their layer's root or, for layers created by DirLayer,
+// prefixed with the layer's directory.
+func (g *Graph) Find(ref string) []*Node {
+	file := filepath.ToSlash(filepath.Clean(ref))
+	var found []*Node
+	for _, n := range g.Nodes() {
+		switch {
+		case n.Name == ref,
+			n.Kind == NodeTemplate && strings.HasPrefix(n.Name, ref+"@"),
+			n.Kind == NodeInclude && (path.Base(n.Name) == ref || path.Base(n.Path) == file),
+			n.Path == file,
+			n.Layer != "" && path.Join(filepath.ToSlash(n.Layer), n.Path) == file:
+			found = append(found, n)
+		}
+	}
+	return found
+}
+
+// Dependents returns every node that depends on any of nodes, directly or
+// transitively, excluding nodes themselves.
+func (g *Graph) Dependents(nodes ...*Node) []*Node {
+	reverse := make(map[*Node][]*Node)
+	for e := range g.edges {
+		reverse[e.To] = append(reverse[e.To], e.From)
+	}
+
+	seen := make(map[*Node]bool, len(nodes))
+	queue := append([]*Node(nil), nodes...)
+	for _, n := range nodes {
+		seen[n] = true
+	}
+
+	var result []*Node
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, dep := range reverse[n] {
+			if !seen[dep] {
+				seen[dep] = true
+				result = append(result, dep)
+				queue = append(queue, dep)
+			}
+		}
+	}
+	sortNodes(result)
+	return result
+}
+
+// nodeShapes maps node kinds to Graphviz shapes.
+var nodeShapes = map[string]string{
+	NodeTemplate: "box",
+	NodeInclude:  "ellipse",
+	NodeChain:    "hexagon",
+}
+
+// DOT renders the graph in the Graphviz DOT language. Edges point from a
+// node to the nodes it depends on.
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph promptkit {\n\trankdir=LR;\n")
+	for _, n := range g.Nodes() {
+		fmt.Fprintf(&b, "\t%q [label=%q, shape=%s];\n", n.Kind+":"+n.Name, n.Name, nodeShapes[n.Kind])
+	}
+	for _, e := range g.Edges() {
+		fmt.Fprintf(&b, "\t%q -> %q", e.From.Kind+":"+e.From.Name, e.To.Kind+":"+e.To.Name)
+		if e.Kind != EdgeUses {
+			fmt.Fprintf(&b, " [label=%q]", e.Kind)
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the graph as a Mermaid flowchart. Edges point from a node
+// to the nodes it depends on.
+func (g *Graph) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+
+	nodes := g.Nodes()
+	ids := make(map[*Node]string, len(nodes))
+	for i, n := range nodes {
+		ids[n] = fmt.Sprintf("n%d", i)
+		label := `"` + strings.ReplaceAll(n.Name, `"`, "#quot;") + `"`
+		switch n.Kind {
+		case NodeInclude:
+			label = "([" + label + "])"
+		case NodeChain:
+			label = "{{" + label + "}}"
+		default:
+			label = "[" + label + "]"
+		}
+		fmt.Fprintf(&b, "    %s%s\n", ids[n], label)
+	}
+	for _, e := range g.Edges() {
+		if e.Kind == EdgeUses {
+			fmt.Fprintf(&b, "    %s --> %s\n", ids[e.From], ids[e.To])
+			continue
+		}
+		fmt.Fprintf(&b, "    %s -- %s --> %s\n", ids[e.From], e.Kind, ids[e.To])
+	}
+	return b.String()
+}
@@ -0,0 +1,171 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func graphFS() fstest.MapFS {
+	return fstest.MapFS{
+		"includes/header.tmpl":         {Data: []byte(`{{ define "rule" }}---{{ end }}{{ template "tone" . }}{{ template "rule" }}`)},
+		"includes/tone.tmpl":           {Data: []byte("friendly")},
+		"includes/unused.tmpl":         {Data: []byte("unused")},
+		"support/includes/tone.tmpl":   {Data: []byte("calm")},
+		"base.tmpl":                    {Data: []byte(`{{ template "header" . }}{{ block "content" . }}{{ end }}`)},
+		"child.tmpl":                   {Data: []byte("---\nextends: base\n---\n{{ define \"content\" }}{{ include \"unused\" }}{{ end }}")},
+		"plain.tmpl":                   {Data: []byte("plain")},
+		"support/triage/classify.tmpl": {Data: []byte(`{{ template "header" . }}`)},
+	}
+}
+
+func edgeStrings(g *Graph) []string {
+	var edges []string
+	for _, e := range g.Edges() {
+		edges = append(edges, e.From.Name+" -"+e.Kind+"-> "+e.To.Name)
+	}
+	return edges
+}
+
+func nodeNames(nodes []*Node) string {
+	names := make([]string, len(nodes))
+	for i, n := range nodes {
+		names[i] = n.Kind + ":" + n.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func TestRegistry_Graph(t *testing.T) {
+	reg := New()
+	if err := reg.LoadFS(graphFS(), "."); err != nil {
+		t.Fatalf("LoadFS error: %v", err)
+	}
+
+	g := reg.Graph()
+	g.AddChain("", "chains/review.yaml", []string{"child", "missing"})
+
+	want := []string{
+		"chains/review.yaml -step-> child",
+		"includes/header -uses-> includes/tone",
+		"includes/header -uses-> support/includes/tone",
+		"base -uses-> includes/header",
+		"child -uses-> includes/unused",
+		"child -extends-> base",
+		"support/triage/classify -uses-> includes/header",
+	}
+	if got := strings.Join(edgeStrings(g), "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("unexpected edges:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"support/includes/tone", "chain:chains/review.yaml,include:includes/header,template:base,template:child,template:support/triage/classify"},
+		{"includes/unused.tmpl", "chain:chains/review.yaml,template:child"},
+		{"base", "chain:chains/review.yaml,template:child"},
+		{"plain", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			nodes := g.Find(tt.ref)
+			if len(nodes) != 1 {
+				t.Fatalf("Find(%q) = %s", tt.ref, nodeNames(nodes))
+			}
+			if got := nodeNames(g.Dependents(nodes...)); got != tt.want {
+				t.Errorf("Dependents = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if got := nodeNames(g.Find("tone")); got != "include:includes/tone,include:support/includes/tone" {
+		t.Errorf("Find(tone) = %s", got)
+	}
+	if got := g.Find("missing"); len(got) != 0 {
+		t.Errorf("Find(missing) = %s", nodeNames(got))
+	}
+}
+
+func TestGraph_Versions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base_v1.tmpl": {Data: []byte("---\nname: base\nversion: 1.0.0\n---\none")},
+		"base_v2.tmpl": {Data: []byte("---\nname: base\nversion: 2.0.0\n---\ntwo")},
+		"child.tmpl":   {Data: []byte("---\nextends: base@^1\n---\n")},
+	}
+	reg := New()
+	if err := reg.LoadFS(fsys, "."); err != nil {
+		t.Fatalf("LoadFS error: %v", err)
+	}
+
+	g := reg.Graph()
+	g.AddChain("review", "review.yaml", []string{"base", "child"})
+
+	want := []string{
+		"review -step-> base@2.0.0",
+		"review -step-> child",
+		"child -extends-> base@1.0.0",
+	}
+	if got := strings.Join(edgeStrings(g), "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("unexpected edges:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+	if got := nodeNames(g.Find("base")); got != "template:base@1.0.0,template:base@2.0.0" {
+		t.Errorf("Find(base) = %s", got)
+	}
+	if got := nodeNames(g.Find("base@1.0.0")); got != "template:base@1.0.0" {
+		t.Errorf("Find(base@1.0.0) = %s", got)
+	}
+}
+
+func TestGraph_FindLayerPath(t *testing.T) {
+	reg, err := Layered(Layer{Name: "prompts", FS: graphFS(), Root: "."})
+	if err != nil {
+		t.Fatalf("Layered error: %v", err)
+	}
+	if got := nodeNames(reg.Graph().Find("./prompts/includes/header.tmpl")); got != "include:includes/header" {
+		t.Errorf("Find = %s", got)
+	}
+	if got := nodeNames(reg.Graph().Find("tone.tmpl")); got != "include:includes/tone,include:support/includes/tone" {
+		t.Errorf("Find by include file name = %s", got)
+	}
+}
+
+func TestGraph_Output(t *testing.T) {
+	reg := New()
+	if err := reg.LoadFS(fstest.MapFS{
+		"includes/tone.tmpl": {Data: []byte("friendly")},
+		"base.tmpl":          {Data: []byte(`{{ template "tone" }}{{ block "body" . }}{{ end }}`)},
+		"child.tmpl":         {Data: []byte("---\nextends: base\n---\n")},
+	}, "."); err != nil {
+		t.Fatalf("LoadFS error: %v", err)
+	}
+	g := reg.Graph()
+	g.AddChain(`say "hi"`, "say.yaml", []string{"child"})
+
+	dot := `digraph promptkit {
+	rankdir=LR;
+	"chain:say \"hi\"" [label="say \"hi\"", shape=hexagon];
+	"include:includes/tone" [label="includes/tone", shape=ellipse];
+	"template:base" [label="base", shape=box];
+	"template:child" [label="child", shape=box];
+	"chain:say \"hi\"" -> "template:child" [label="step"];
+	"template:base" -> "include:includes/tone";
+	"template:child" -> "template:base" [label="extends"];
+}
+`
+	if got := g.DOT(); got != dot {
+		t.Errorf("unexpected DOT:\n%s", got)
+	}
+
+	mermaid := `flowchart LR
+    n0{{"say #quot;hi#quot;"}}
+    n1(["includes/tone"])
+    n2["base"]
+    n3["child"]
+    n0 -- step --> n3
+    n2 --> n1
+    n3 -- extends --> n2
+`
+	if got := g.Mermaid(); got != mermaid {
+		t.Errorf("unexpected Mermaid:\n%s", got)
+	}
+}
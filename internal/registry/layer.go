@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"io/fs"
+	"os"
+)
+
+// Layer is a source of templates in a layered registry.
+type Layer struct {
+	// Name identifies the layer in Template.Layer and in errors.
+	Name string
+
+	FS   fs.FS
+	Root string
+}
+
+// DirLayer returns a layer that reads templates from dir, named after dir.
+func DirLayer(dir string) Layer {
+	return Layer{Name: dir, FS: os.DirFS(dir), Root: "."}
+}
+
+// Layered creates a Registry composed of layers. Later layers shadow earlier
+// ones: a template or include with the same name in a later layer replaces
+// the earlier one, and includes are resolved after all layers are loaded, so
+// a template from a base layer uses includes overridden by later layers.
+func Layered(layers ...Layer) (*Registry, error) {
+	r := New()
+	if err := r.LoadLayers(layers...); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// LoadLayers loads each layer in order on top of the registry's current
+// templates, then compiles the result once. On error the registry is left
+// unchanged.
+func (r *Registry) LoadLayers(layers ...Layer) error {
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+
+	return r.swap(r.current.Load().clone(), layers)
+}
+
+// ReloadLayers replaces the contents of the registry with layers, loaded like
+// LoadLayers. On error the registry is left unchanged.
+func (r *Registry) ReloadLayers(layers ...Layer) error {
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+
+	return r.swap(newSet(), layers)
+}
@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLayered(t *testing.T) {
+	base := fstest.MapFS{
+		"includes/tone.tmpl": {Data: []byte("neutral")},
+		"greet.tmpl":         {Data: []byte(`Hello ({{ template "tone" }})`)},
+		"bye.tmpl":           {Data: []byte("Bye")},
+		"support/help.tmpl":  {Data: []byte("Base help")},
+	}
+	org := fstest.MapFS{
+		"includes/tone.tmpl": {Data: []byte("warm")},
+		"support/help.tmpl":  {Data: []byte("Org help")},
+	}
+	local := fstest.MapFS{
+		"bye.tmpl": {Data: []byte("See you")},
+	}
+
+	reg, err := Layered(
+		Layer{Name: "base", FS: base, Root: "."},
+		Layer{Name: "org", FS: org, Root: "."},
+		Layer{Name: "local", FS: local, Root: "."},
+	)
+	if err != nil {
+		t.Fatalf("Layered error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		output string
+		layer  string
+	}{
+		{"greet", "Hello (warm)", "base"},
+		{"bye", "See you", "local"},
+		{"support/help", "Org help", "org"},
+	}
+	for _, tt := range tests {
+		result, err := reg.Render(tt.name, nil)
+		if err != nil {
+			t.Errorf("Render(%q) error: %v", tt.name, err)
+			continue
+		}
+		if result.Output != tt.output {
+			t.Errorf("Render(%q) = %q, want %q", tt.name, result.Output, tt.output)
+		}
+		tmpl, _ := reg.Get(tt.name)
+		if tmpl.Layer != tt.layer {
+			t.Errorf("%q loaded from layer %q, want %q", tt.name, tmpl.Layer, tt.layer)
+		}
+	}
+
+	if len(reg.List()) != 3 {
+		t.Errorf("expected 3 templates, got %d", len(reg.List()))
+	}
+}
+
+func TestLayered_IncludeNeededByLaterLayer(t *testing.T) {
+	// A base template may rely on an include that only a later layer provides.
+	base := fstest.MapFS{"greet.tmpl": {Data: []byte(`{{ template "sig" }}`)}}
+	local := fstest.MapFS{"includes/sig.tmpl": {Data: []byte("-- local")}}
+
+	reg, err := Layered(Layer{Name: "base", FS: base, Root: "."}, Layer{Name: "local", FS: local, Root: "."})
+	if err != nil {
+		t.Fatalf("Layered error: %v", err)
+	}
+	result, err := reg.Render("greet", nil)
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if result.Output != "-- local" {
+		t.Errorf("unexpected output: %q", result.Output)
+	}
+}
+
+func TestLayered_IncludeDefinePrecedence(t *testing.T) {
+	base := fstest.MapFS{
+		"includes/z_tone.tmpl":         {Data: []byte(`{{ define "voice" }}base{{ end }}`)},
+		"greet.tmpl":                   {Data: []byte(`{{ template "voice" }}`)},
+		"support/help.tmpl":            {Data: []byte(`{{ template "voice" }}`)},
+		"support/includes/a_tone.tmpl": {Data: []byte(`{{ define "voice" }}support{{ end }}`)},
+	}
+	org := fstest.MapFS{
+		"includes/a_tone.tmpl": {Data: []byte(`{{ define "voice" }}org{{ end }}`)},
+	}
+
+	// Load repeatedly: with map order deciding, some loads would differ.
+	for range 20 {
+		reg, err := Layered(
+			Layer{Name: "base", FS: base, Root: "."},
+			Layer{Name: "org", FS: org, Root: "."},
+		)
+		if err != nil {
+			t.Fatalf("Layered error: %v", err)
+		}
+		for name, want := range map[string]string{"greet": "org", "support/help": "support"} {
+			result, err := reg.Render(name, nil)
+			if err != nil {
+				t.Fatalf("Render(%q) error: %v", name, err)
+			}
+			if result.Output != want {
+				t.Fatalf("Render(%q) = %q, want %q", name, result.Output, want)
+			}
+		}
+	}
+}
+
+func TestLayered_MissingLayer(t *testing.T) {
+	_, err := Layered(Layer{Name: "base", FS: fstest.MapFS{}, Root: "missing"})
+	if err == nil {
+		t.Fatal("expected error for missing layer root")
+	}
+}
+
+func TestLayered_Versions(t *testing.T) {
+	base := fstest.MapFS{
+		"greet.tmpl":    {Data: []byte("---\nversion: 1.0.0\n---\nbase 1")},
+		"greet-2.tmpl":  {Data: []byte("---\nname: greet\nversion: 2.0.0\n---\nbase 2")},
+		"summary.tmpl":  {Data: []byte("---\nversion: 1.0.0\n---\nbase summary")},
+		"classify.tmpl": {Data: []byte("---\nversion: 1.0.0\n---\nbase classify")},
+	}
+	override := fstest.MapFS{
+		// Unversioned over versioned.
+		"greet.tmpl": {Data: []byte("override")},
+		// An older version still shadows the base.
+		"summary.tmpl": {Data: []byte("---\nversion: 0.9.0\n---\noverride summary")},
+	}
+
+	reg, err := Layered(
+		Layer{Name: "base", FS: base, Root: "."},
+		Layer{Name: "override", FS: override, Root: "."},
+	)
+	if err != nil {
+		t.Fatalf("Layered error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		output   string
+		versions int
+	}{
+		{"greet", "override", 1},
+		{"summary", "override summary", 1},
+		{"classify", "base classify", 1},
+	}
+	for _, tt := range tests {
+		result, err := reg.Render(tt.name, nil)
+		if err != nil {
+			t.Errorf("Render(%q) error: %v", tt.name, err)
+			continue
+		}
+		if result.Output != tt.output {
+			t.Errorf("Render(%q) = %q, want %q", tt.name, result.Output, tt.output)
+		}
+		if n := len(reg.Versions(tt.name)); n != tt.versions {
+			t.Errorf("%q has %d versions, want %d", tt.name, n, tt.versions)
+		}
+	}
+	if _, err := reg.Get("greet@2.0.0"); err == nil {
+		t.Error("expected the base greet@2.0.0 to be shadowed")
+	}
+}
+
+func TestLayered_VersionDiagnosticLayer(t *testing.T) {
+	base := fstest.MapFS{"greet.tmpl": {Data: []byte("base")}}
+	override := fstest.MapFS{
+		"greet.tmpl":   {Data: []byte("---\nversion: 1.0.0\n---\nversioned")},
+		"greet-x.tmpl": {Data: []byte("---\nname: greet\n---\nunversioned")},
+	}
+
+	_, err := Layered(
+		Layer{Name: "base", FS: base, Root: "."},
+		Layer{Name: "override", FS: override, Root: "."},
+	)
+	var loadErr *LoadError
+	if !errors.As(err, &loadErr) || len(loadErr.Diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", err)
+	}
+	d := loadErr.Diagnostics[0]
+	if d.Kind != KindVersion || d.Layer != "override" || d.Path != "greet-x.tmpl" || d.Other != "greet.tmpl" {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+	want := `layer "override": greet-x.tmpl: template "greet" has no version but greet.tmpl versions it`
+	if d.Error() != want {
+		t.Errorf("Error() = %q, want %q", d.Error(), want)
+	}
+}
@@ -1,14 +1,27 @@
-// Package registry loads and indexes template files from a directory.
+// Package registry loads and indexes template files from a directory or any
+// fs.FS.
 package registry
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
-	"path/filepath"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template/parse"
 
 	"github.com/devaloi/promptkit/internal/config"
+	"github.com/devaloi/promptkit/internal/engine"
 	"github.com/devaloi/promptkit/internal/frontmatter"
+	"github.com/devaloi/promptkit/internal/semver"
+	"github.com/devaloi/promptkit/internal/validator"
 )
 
 // Template holds a parsed template file with its metadata and raw content.
@@ -17,125 +30,892 @@ type Template struct {
 	Meta    frontmatter.Metadata
 	Body    string
 	Content string
+
+	// Path is the file the template was loaded from, relative to the root of
+	// the file system it was loaded from.
+	Path string
+
+	// Layer is the name of the layer the template was loaded from; see
+	// Layered.
+	Layer string
+
+	// Version is the template's semantic version in canonical form, or ""
+	// if the template declares none.
+	Version string
+
+	// IncludeVars lists the variables added to Meta.RequiredVars because an
+	// include the template renders with its root data requires them.
+	IncludeVars []string
+
+	// scope is the template's directory relative to the load root; it selects
+	// the includes visible to the template.
+	scope    string
+	version  semver.Version
+	compiled *engine.Compiled
+}
+
+// Ref returns the reference that selects exactly this template:
+// "name@version", or the name alone for an unversioned template.
+func (t *Template) Ref() string {
+	if t.Version == "" {
+		return t.Name
+	}
+	return t.Name + "@" + t.Version
 }
 
-// Registry holds loaded templates indexed by name.
+// Declared returns the template's metadata as declared in its frontmatter and
+// inherited through extends, without the IncludeVars.
+func (t *Template) Declared() frontmatter.Metadata {
+	meta := t.Meta
+	meta.RequiredVars = slices.DeleteFunc(slices.Clone(meta.RequiredVars), func(v string) bool {
+		return slices.Contains(t.IncludeVars, v)
+	})
+	return meta
+}
+
+// newer reports whether t sorts before o in a list of versions, newest
+// first. Unversioned templates sort after every versioned one.
+func (t *Template) newer(o *Template) bool {
+	if (t.Version == "") != (o.Version == "") {
+		return o.Version == ""
+	}
+	return t.version.Compare(o.version) > 0
+}
+
+// Include holds a parsed include file with its metadata and raw content.
+type Include struct {
+	Name    string
+	Meta    frontmatter.Metadata
+	Body    string
+	Content string
+
+	// Path is the file the include was loaded from, relative to the root of
+	// the file system it was loaded from.
+	Path string
+
+	// Layer is the name of the layer the include was loaded from.
+	Layer string
+
+	// Scope is the directory, relative to the load root, whose subtree can use
+	// the include; "." for the root includes/ directory.
+	Scope string
+
+	// defines lists the template names the include defines: its own name and
+	// those of its {{ define }} blocks.
+	defines []string
+
+	// load counts the layers loaded before the one the include came from.
+	load int
+}
+
+// Registry holds loaded templates indexed by name. It is safe for concurrent
+// use: loads build a new set of templates and swap it in only once every
+// template has compiled, so readers never observe a partial load.
 type Registry struct {
+	current atomic.Pointer[set]
+
+	// loadMu serializes loads so that concurrent loads do not lose updates.
+	loadMu sync.Mutex
+	opts   Options
+}
+
+// set is an immutable snapshot of loaded templates and includes.
+type set struct {
+	// templates maps the Ref of each template to it.
 	templates map[string]*Template
-	includes  map[string]string
+
+	// versions maps each template name to its versions, newest first, and
+	// latest to the version selected by the bare name. Both are rebuilt by
+	// index.
+	versions map[string][]*Template
+	latest   map[string]*Template
+
+	// includes maps a directory relative to the load root ("." for the root
+	// itself) to the include templates in its includes/ subdirectory.
+	includes map[string]map[string]*Include
+
+	// loads counts the layers loaded into the set.
+	loads int
+
+	// layers lists the layers loaded into the set, in order.
+	layers []Layer
+
+	// diagnostics lists the problems found by the load that produced the set.
+	diagnostics []*Diagnostic
 }
 
-// New creates an empty Registry.
-func New() *Registry {
-	return &Registry{
+func newSet() *set {
+	return &set{
 		templates: make(map[string]*Template),
-		includes:  make(map[string]string),
+		includes:  make(map[string]map[string]*Include),
 	}
 }
 
-// LoadDir loads all .tmpl files from dir and its includes/ subdirectory.
-func (r *Registry) LoadDir(dir string) error {
-	includesDir := filepath.Join(dir, config.IncludesDir)
+// clone returns a copy of s that can be loaded into without affecting s.
+func (s *set) clone() *set {
+	c := &set{
+		templates: make(map[string]*Template, len(s.templates)),
+		includes:  make(map[string]map[string]*Include, len(s.includes)),
+		loads:     s.loads,
+		layers:    slices.Clip(s.layers),
+	}
+	for name, tmpl := range s.templates {
+		c.templates[name] = tmpl
+	}
+	for scope, incs := range s.includes {
+		c.includes[scope] = make(map[string]*Include, len(incs))
+		for name, inc := range incs {
+			c.includes[scope][name] = inc
+		}
+	}
+	return c
+}
+
+// index rebuilds s.versions and s.latest from s.templates. The latest
+// version of a name is its newest release, or its newest pre-release if it has
+// no releases.
+func (s *set) index() {
+	s.versions = make(map[string][]*Template)
+	for _, tmpl := range s.templates {
+		s.versions[tmpl.Name] = append(s.versions[tmpl.Name], tmpl)
+	}
+
+	s.latest = make(map[string]*Template, len(s.versions))
+	for name, versions := range s.versions {
+		sort.Slice(versions, func(i, j int) bool { return versions[i].newer(versions[j]) })
+		s.latest[name] = versions[0]
+		for _, tmpl := range versions {
+			if !tmpl.version.Prerelease() {
+				s.latest[name] = tmpl
+				break
+			}
+		}
+	}
+}
 
-	// Load includes first.
-	if info, err := os.Stat(includesDir); err == nil && info.IsDir() {
-		if err := r.loadIncludes(includesDir); err != nil {
-			return fmt.Errorf("loading includes: %w", err)
+// checkVersions reports and removes the unversioned templates loaded from
+// layer that share their name with versioned ones from the same layer, since
+// a bare name selects the latest version and could never reach them. seen
+// maps the refs loaded from the layer to their files.
+func (s *set) checkVersions(layer Layer, seen map[string]string) {
+	versioned := make(map[string]*Template)
+	for ref := range seen {
+		tmpl := s.templates[ref]
+		if other, ok := versioned[tmpl.Name]; tmpl.Version != "" && (!ok || tmpl.Path < other.Path) {
+			versioned[tmpl.Name] = tmpl
 		}
 	}
+	for ref := range seen {
+		tmpl := s.templates[ref]
+		other, ok := versioned[tmpl.Name]
+		if tmpl.Version != "" || !ok {
+			continue
+		}
+		s.report(&Diagnostic{
+			Kind:  KindVersion,
+			Path:  tmpl.Path,
+			Layer: layer.Name,
+			Name:  tmpl.Name,
+			Other: other.Path,
+			Err:   fmt.Errorf("template %q has no version but %s versions it", tmpl.Name, other.Path),
+		})
+		delete(s.templates, ref)
+	}
+}
 
-	// Load top-level templates.
-	entries, err := os.ReadDir(dir)
+// shadow removes the templates loaded before layer whose names the layer
+// also defines, so that a later layer replaces every version of a name
+// rather than adding to them. seen maps the refs loaded from the layer to
+// their files.
+func (s *set) shadow(seen map[string]string) {
+	names := make(map[string]bool, len(seen))
+	for ref := range seen {
+		names[s.templates[ref].Name] = true
+	}
+	for ref, tmpl := range s.templates {
+		if _, ok := seen[ref]; !ok && names[tmpl.Name] {
+			delete(s.templates, ref)
+		}
+	}
+}
+
+// lookup finds the template ref refers to: "name" selects the latest version
+// of name, and "name@constraint" the newest version matching constraint.
+func (s *set) lookup(ref string) (*Template, error) {
+	name, constraint, pinned := strings.Cut(ref, "@")
+	if !pinned {
+		if tmpl, ok := s.latest[name]; ok {
+			return tmpl, nil
+		}
+		return nil, fmt.Errorf("template %q not found", name)
+	}
+
+	if tmpl, ok := s.templates[ref]; ok {
+		return tmpl, nil
+	}
+	versions, ok := s.versions[name]
+	if !ok {
+		return nil, fmt.Errorf("template %q not found", name)
+	}
+	c, err := semver.ParseConstraint(constraint)
 	if err != nil {
-		return fmt.Errorf("reading directory %q: %w", dir, err)
+		return nil, fmt.Errorf("template %q: %w", ref, err)
+	}
+	for _, tmpl := range versions {
+		if tmpl.Version != "" && c.Check(tmpl.version) {
+			return tmpl, nil
+		}
 	}
+	return nil, fmt.Errorf("no version of template %q matches %q", name, constraint)
+}
 
-	for _, entry := range entries {
-		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tmpl") {
-			continue
+// scoped returns the includes visible from scope: those of scope itself and
+// of every parent directory up to the root, with nearer includes shadowing
+// those further up.
+func (s *set) scoped(scope string) map[string]*Include {
+	merged := make(map[string]*Include)
+	for {
+		for name, inc := range s.includes[scope] {
+			if _, ok := merged[name]; !ok {
+				merged[name] = inc
+			}
 		}
+		if scope == "." {
+			return merged
+		}
+		scope = path.Dir(scope)
+	}
+}
 
-		path := filepath.Join(dir, entry.Name())
-		if err := r.loadTemplate(path); err != nil {
-			return fmt.Errorf("loading template %q: %w", path, err)
+// ordered returns incs in the order they are parsed in, so that when several
+// define the same template the nearest scope wins, and within a scope the
+// latest layer: from the farthest scope to the nearest, and within a scope
+// from the earliest layer to the latest.
+func ordered(incs map[string]*Include) []engine.Include {
+	sorted := make([]*Include, 0, len(incs))
+	for _, inc := range incs {
+		sorted = append(sorted, inc)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if depth(a.Scope) != depth(b.Scope) {
+			return depth(a.Scope) < depth(b.Scope)
 		}
+		if a.load != b.load {
+			return a.load < b.load
+		}
+		return a.Name < b.Name
+	})
+	result := make([]engine.Include, len(sorted))
+	for i, inc := range sorted {
+		result[i] = engine.Include{Name: inc.Name, Content: inc.Content}
 	}
+	return result
+}
 
+// depth returns the number of directories in scope; 0 for the root.
+func depth(scope string) int {
+	if scope == "." {
+		return 0
+	}
+	return strings.Count(scope, "/") + 1
+}
+
+// contents returns the content of each include in incs, keyed by name.
+func contents(incs map[string]*Include) map[string]string {
+	m := make(map[string]string, len(incs))
+	for name, inc := range incs {
+		m[name] = inc.Content
+	}
+	return m
+}
+
+// Options configures a Registry.
+type Options struct {
+	// Lenient makes loads keep every template that loads and compiles, and
+	// record the problems with the rest in Diagnostics, instead of failing
+	// with a *LoadError.
+	Lenient bool
+}
+
+// New creates an empty Registry.
+func New() *Registry {
+	return NewWithOptions(Options{})
+}
+
+// NewWithOptions creates an empty Registry configured by opts.
+func NewWithOptions(opts Options) *Registry {
+	r := &Registry{opts: opts}
+	r.current.Store(newSet())
+	return r
+}
+
+// LoadDir loads all .tmpl files from dir and its subdirectories, then compiles
+// every loaded template together with the includes in its scope. Each
+// includes/ directory holds include templates for its parent directory's
+// subtree. Templates already in the registry are kept unless dir defines one
+// with the same name, which replaces all of their versions.
+//
+// Problems with individual files are collected and returned together as a
+// *LoadError, leaving the registry unchanged; see Options.Lenient.
+func (r *Registry) LoadDir(dir string) error {
+	if err := r.LoadFS(os.DirFS(dir), "."); err != nil {
+		return fmt.Errorf("loading %q: %w", dir, err)
+	}
 	return nil
 }
 
-func (r *Registry) loadTemplate(path string) error {
-	data, err := os.ReadFile(path)
+// LoadFS loads templates like LoadDir from the root directory of fsys, such
+// as an embed.FS or a zip archive.
+func (r *Registry) LoadFS(fsys fs.FS, root string) error {
+	return r.LoadLayers(Layer{FS: fsys, Root: root})
+}
+
+// Reload replaces the contents of the registry with the templates in dir. On
+// error the registry is left unchanged.
+func (r *Registry) Reload(dir string) error {
+	if err := r.ReloadFS(os.DirFS(dir), "."); err != nil {
+		return fmt.Errorf("loading %q: %w", dir, err)
+	}
+	return nil
+}
+
+// ReloadFS replaces the contents of the registry with the templates in the
+// root directory of fsys. On error the registry is left unchanged.
+func (r *Registry) ReloadFS(fsys fs.FS, root string) error {
+	return r.ReloadLayers(Layer{FS: fsys, Root: root})
+}
+
+// swap loads layers into s in order, compiles the result and makes s current
+// unless the load failed.
+func (r *Registry) swap(s *set, layers []Layer) error {
+	s.layers = append(s.layers, layers...)
+	for _, layer := range layers {
+		if err := s.load(layer); err != nil {
+			if layer.Name != "" {
+				return fmt.Errorf("layer %q: %w", layer.Name, err)
+			}
+			return err
+		}
+	}
+	s.compile()
+
+	if len(s.diagnostics) > 0 && !r.opts.Lenient {
+		return &LoadError{Diagnostics: s.diagnostics}
+	}
+	r.current.Store(s)
+	return nil
+}
+
+// load adds the templates and includes of layer to s, replacing any with the
+// same name; a template name the layer defines replaces every version of it
+// loaded before. Problems with individual files are recorded in
+// s.diagnostics; only a root directory that cannot be read fails the load.
+func (s *set) load(layer Layer) error {
+	s.loads++
+	first := len(s.diagnostics)
+	fsys, root := layer.FS, layer.Root
+	// seen maps the template names loaded so far to their files.
+	seen := make(map[string]string)
+
+	err := fs.WalkDir(fsys, root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if file == root {
+				return fmt.Errorf("reading directory %q: %w", file, err)
+			}
+			s.report(&Diagnostic{Kind: KindUnreadable, Path: file, Err: err})
+			if entry != nil && entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if entry.IsDir() {
+			if entry.Name() != config.IncludesDir || file == root {
+				return nil
+			}
+			s.loadIncludes(layer, file, scopeOf(root, path.Dir(file)))
+			return fs.SkipDir
+		}
+
+		if !strings.HasSuffix(entry.Name(), ".tmpl") {
+			return nil
+		}
+		s.loadTemplate(layer, file, scopeOf(root, path.Dir(file)), seen)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
+	s.shadow(seen)
+	s.checkVersions(layer, seen)
+	for _, d := range s.diagnostics[first:] {
+		d.Layer = layer.Name
+	}
+	return nil
+}
+
+// report records a problem found while loading.
+func (s *set) report(d *Diagnostic) {
+	s.diagnostics = append(s.diagnostics, d)
+}
+
+// scopeOf returns dir relative to root.
+func scopeOf(root, dir string) string {
+	if root == "." {
+		return dir
+	}
+	if dir == root {
+		return "."
+	}
+	return strings.TrimPrefix(dir, root+"/")
+}
+
+// compile parses every template with the includes in its scope. Templates are
+// recompiled on each load so that includes added later are picked up; each is
+// copied first because the previous snapshot may still be in use. Templates
+// that extend an unknown template, are part of an extends cycle or fail to
+// compile are reported and removed.
+//
+// The required variables of every include a template renders with its root
+// data, as in {{ template "name" . }}, directly or through other includes that
+// pass it on, are added to the template's own and listed in IncludeVars.
+func (s *set) compile() {
+	s.index()
+	defer s.index()
+
+	names := make([]string, 0, len(s.templates))
+	for name := range s.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Resolve every extends chain before any template is removed.
+	lineages := make(map[string][]*Template, len(names))
+	for _, name := range names {
+		tmpl := s.templates[name]
+		lineage, err := s.lineage(tmpl)
+		if err != nil {
+			s.report(&Diagnostic{Kind: KindExtends, Path: tmpl.Path, Layer: tmpl.Layer, Name: tmpl.Name, Err: err})
+			continue
+		}
+		lineages[name] = lineage
+	}
+
+	for _, name := range names {
+		tmpl := s.templates[name]
+		lineage, ok := lineages[name]
+		if !ok {
+			delete(s.templates, name)
+			continue
+		}
+
+		parents := make([]engine.Parent, len(lineage)-1)
+		for i, p := range lineage[:len(lineage)-1] {
+			parents[i] = engine.Parent{Name: p.Ref(), Content: p.Content}
+		}
+		scoped := s.scoped(tmpl.scope)
+		includes := contents(scoped)
+		compiled, err := engine.CompileExtends(name, tmpl.Content, parents, ordered(scoped))
+		if err != nil {
+			s.report(&Diagnostic{
+				Kind:  KindCompile,
+				Path:  tmpl.Path,
+				Layer: tmpl.Layer,
+				Line:  templateLine(err, name),
+				Name:  tmpl.Name,
+				Err:   fmt.Errorf("compiling template %q: %w", name, err),
+			})
+			delete(s.templates, name)
+			continue
+		}
+		bodies := make([]string, len(lineage))
+		for i, l := range lineage {
+			bodies[i] = l.Body
+		}
+		// Compilation succeeded, so the bodies and includes parse.
+		usage, _ := validator.AnalyzeInherited(bodies, includes)
+		var added []string
+		for _, used := range usage.Rooted {
+			if inc, ok := scoped[used]; ok {
+				for _, v := range inc.Meta.RequiredVars {
+					if !slices.Contains(compiled.Meta.RequiredVars, v) {
+						added = appendMissing(added, v)
+					}
+				}
+			}
+		}
+		compiled.Meta.RequiredVars = append(slices.Clip(compiled.Meta.RequiredVars), added...)
+
+		t := *tmpl
+		t.Meta = compiled.Meta
+		t.IncludeVars = added
+		t.compiled = compiled
+		s.templates[name] = &t
+	}
+}
+
+// appendMissing appends each of names not already in list.
+func appendMissing(list []string, names ...string) []string {
+	for _, name := range names {
+		if !slices.Contains(list, name) {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+// lineage returns the extends chain of tmpl, from the root ancestor to tmpl
+// itself.
+func (s *set) lineage(tmpl *Template) ([]*Template, error) {
+	lineage := []*Template{tmpl}
+	for t := tmpl; t.Meta.Extends != ""; {
+		parent, ok := s.resolve(t.Meta.Extends, t.scope)
+		if !ok {
+			return nil, fmt.Errorf("template %q extends unknown template %q", t.Name, t.Meta.Extends)
+		}
+		if i := slices.Index(lineage, parent); i >= 0 {
+			var names []string
+			for _, l := range append(lineage[i:], parent) {
+				names = append(names, l.Name)
+			}
+			return nil, fmt.Errorf("extends cycle: %s", strings.Join(names, " -> "))
+		}
+		lineage = append(lineage, parent)
+		t = parent
+	}
+	slices.Reverse(lineage)
+	return lineage, nil
+}
+
+// resolve looks up the template ref refers to as seen from scope: relative
+// to scope first, then to each parent directory up to the root. ref may pin
+// a version like lookup.
+func (s *set) resolve(ref, scope string) (*Template, bool) {
+	for {
+		name := ref
+		if scope != "." {
+			name = path.Join(scope, ref)
+		}
+		if tmpl, err := s.lookup(name); err == nil {
+			return tmpl, true
+		}
+		if scope == "." {
+			return nil, false
+		}
+		scope = path.Dir(scope)
+	}
+}
+
+// parseLinePattern matches the "name:line:" location in text/template parse
+// errors.
+var parseLinePattern = regexp.MustCompile(`template: ([^:\s]+):(\d+):`)
+
+// templateLine returns the line reported by a text/template parse error in
+// the template called name, or 0 if the error is located elsewhere.
+func templateLine(err error, name string) int {
+	m := parseLinePattern.FindStringSubmatch(err.Error())
+	if m == nil || m[1] != name {
+		return 0
+	}
+	line, _ := strconv.Atoi(m[2])
+	return line
+}
+
+// loadTemplate loads file as a template. Templates below the load root are
+// namespaced by their directory, so support/classify.tmpl is named
+// "support/classify". A file whose name and version were already loaded from
+// another file in the same load, or whose version is invalid, is reported and
+// skipped.
+func (s *set) loadTemplate(layer Layer, file, scope string, seen map[string]string) {
+	data, err := fs.ReadFile(layer.FS, file)
+	if err != nil {
+		s.report(&Diagnostic{Kind: KindUnreadable, Path: file, Err: err})
+		return
+	}
+
 	content := string(data)
-	parsed, fmErr := frontmatter.Parse(content)
+	parsed, ok := s.parseFrontmatter(file, content)
+	if !ok {
+		return
+	}
 
 	name := parsed.Meta.Name
 	if name == "" {
 		// Use filename without extension as fallback name.
-		name = strings.TrimSuffix(filepath.Base(path), ".tmpl")
+		name = strings.TrimSuffix(path.Base(file), ".tmpl")
+	}
+	if scope != "." {
+		name = path.Join(scope, name)
 	}
 
 	tmpl := &Template{
 		Name:    name,
+		Meta:    parsed.Meta,
 		Content: content,
 		Body:    parsed.Body,
+		Path:    file,
+		Layer:   layer.Name,
+		scope:   scope,
+	}
+	if v := parsed.Meta.Version; v != "" {
+		version, err := semver.Parse(v)
+		if err != nil {
+			s.report(&Diagnostic{Kind: KindVersion, Path: file, Name: name, Err: err})
+			return
+		}
+		tmpl.Version = version.String()
+		tmpl.version = version
 	}
 
-	if fmErr == nil {
-		tmpl.Meta = parsed.Meta
+	ref := tmpl.Ref()
+	if other, ok := seen[ref]; ok {
+		s.report(&Diagnostic{
+			Kind:  KindDuplicateName,
+			Path:  file,
+			Name:  name,
+			Other: other,
+			Err:   fmt.Errorf("template name %q is already used by %s", ref, other),
+		})
+		return
 	}
+	seen[ref] = file
 
-	r.templates[name] = tmpl
-	return nil
+	s.templates[ref] = tmpl
 }
 
-func (r *Registry) loadIncludes(dir string) error {
-	entries, err := os.ReadDir(dir)
+// parseFrontmatter parses the frontmatter of file. Content without frontmatter
+// has empty metadata and is its own body; malformed frontmatter is reported
+// and ok is false.
+func (s *set) parseFrontmatter(file, content string) (parsed frontmatter.Result, ok bool) {
+	parsed, err := frontmatter.Parse(content)
+	if errors.Is(err, frontmatter.ErrNoFrontmatter) {
+		return frontmatter.Result{Body: content}, true
+	}
 	if err != nil {
-		return err
+		d := &Diagnostic{Kind: KindFrontmatter, Path: file, Err: err}
+		var pe *frontmatter.ParseError
+		if errors.As(err, &pe) {
+			d.Line = pe.Line
+		}
+		s.report(d)
+		return frontmatter.Result{}, false
 	}
+	return parsed, true
+}
 
+// loadIncludes loads the .tmpl files in dir as includes visible to scope and
+// its subdirectories. Includes may start with frontmatter like templates. An
+// include that does not parse, or that defines a template already defined by
+// another include in dir, is reported and skipped.
+func (s *set) loadIncludes(layer Layer, dir, scope string) {
+	fsys := layer.FS
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		s.report(&Diagnostic{Kind: KindUnreadable, Path: dir, Err: err})
+		return
+	}
+
+	if s.includes[scope] == nil {
+		s.includes[scope] = make(map[string]*Include)
+	}
+
+	// defined maps the template names defined so far to their files.
+	defined := make(map[string]string)
 	for _, entry := range entries {
 		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tmpl") {
 			continue
 		}
 
-		path := filepath.Join(dir, entry.Name())
-		data, err := os.ReadFile(path)
+		file := path.Join(dir, entry.Name())
+		data, err := fs.ReadFile(fsys, file)
 		if err != nil {
-			return err
+			s.report(&Diagnostic{Kind: KindUnreadable, Path: file, Err: err})
+			continue
+		}
+
+		content := string(data)
+		parsed, ok := s.parseFrontmatter(file, content)
+		if !ok {
+			continue
 		}
 
 		name := strings.TrimSuffix(entry.Name(), ".tmpl")
-		r.includes[name] = string(data)
-	}
+		// Pad the body to its place in the file so errors report file lines.
+		names, err := definedNames(name, strings.Repeat("\n", parsed.Offset)+parsed.Body)
+		if err != nil {
+			s.report(&Diagnostic{Kind: KindCompile, Path: file, Line: templateLine(err, name), Name: name, Err: err})
+			continue
+		}
 
-	return nil
+		collided := false
+		for _, n := range names {
+			if other, ok := defined[n]; ok {
+				s.report(&Diagnostic{
+					Kind:  KindIncludeCollision,
+					Path:  file,
+					Name:  n,
+					Other: other,
+					Err:   fmt.Errorf("include %q is also defined in %s", n, other),
+				})
+				collided = true
+			}
+		}
+		if collided {
+			continue
+		}
+		for _, n := range names {
+			defined[n] = file
+		}
+
+		s.includes[scope][name] = &Include{
+			Name:    name,
+			Meta:    parsed.Meta,
+			Body:    parsed.Body,
+			Content: content,
+			Path:    file,
+			Layer:   layer.Name,
+			Scope:   scope,
+			defines: names,
+			load:    s.loads,
+		}
+	}
 }
 
-// Get retrieves a template by name.
-func (r *Registry) Get(name string) (*Template, error) {
-	tmpl, ok := r.templates[name]
-	if !ok {
-		return nil, fmt.Errorf("template %q not found", name)
+// definedNames parses the include name with text and returns the template
+// names it defines: name itself and every {{ define }} block.
+func definedNames(name, text string) ([]string, error) {
+	tree := parse.New(name)
+	tree.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	if _, err := tree.Parse(text, "", "", trees); err != nil {
+		return nil, err
+	}
+
+	names := []string{name}
+	for n := range trees {
+		if n != name {
+			names = append(names, n)
+		}
 	}
-	return tmpl, nil
+	sort.Strings(names[1:])
+	return names, nil
 }
 
-// List returns all loaded templates.
+// Get retrieves a template by reference: a name selects the latest version
+// of the template, and "name@constraint" the newest version matching a
+// semantic version constraint such as "summarize@1.2.0" or "summarize@^1.2".
+// Unversioned templates are only selected by their bare name.
+func (r *Registry) Get(ref string) (*Template, error) {
+	return r.current.Load().lookup(ref)
+}
+
+// Versions returns every version of the named template, newest first.
+func (r *Registry) Versions(name string) []*Template {
+	return slices.Clone(r.current.Load().versions[name])
+}
+
+// List returns all loaded templates, including every version.
 func (r *Registry) List() []*Template {
-	result := make([]*Template, 0, len(r.templates))
-	for _, tmpl := range r.templates {
+	templates := r.current.Load().templates
+	result := make([]*Template, 0, len(templates))
+	for _, tmpl := range templates {
 		result = append(result, tmpl)
 	}
 	return result
 }
 
-// Includes returns the loaded include templates.
+// Lineage returns the extends chain of the template ref refers to, from the
+// root ancestor to the template itself.
+func (r *Registry) Lineage(ref string) ([]*Template, error) {
+	s := r.current.Load()
+	tmpl, err := s.lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+	return s.lineage(tmpl)
+}
+
+// Diagnostics returns the problems found by the most recent load. It is only
+// non-empty for lenient registries, since other registries reject loads with
+// problems.
+func (r *Registry) Diagnostics() []*Diagnostic {
+	return r.current.Load().diagnostics
+}
+
+// Includes returns the include templates loaded from the root includes/
+// directory, keyed by name.
 func (r *Registry) Includes() map[string]string {
-	return r.includes
+	return contents(r.current.Load().includes["."])
+}
+
+// ListIncludes returns every loaded include with its metadata, sorted by path.
+func (r *Registry) ListIncludes() []*Include {
+	var result []*Include
+	for _, incs := range r.current.Load().includes {
+		for _, inc := range incs {
+			result = append(result, inc)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// GetInclude returns the named include as seen by the template ref refers
+// to.
+func (r *Registry) GetInclude(ref, name string) (*Include, error) {
+	s := r.current.Load()
+	tmpl, err := s.lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+	inc, ok := s.scoped(tmpl.scope)[name]
+	if !ok {
+		return nil, fmt.Errorf("include %q not found for template %q", name, ref)
+	}
+	return inc, nil
+}
+
+// IncludesFor returns the include templates visible to the template ref
+// refers to: those in the includes/ directory next to it and in every parent
+// directory, with nearer includes taking precedence.
+func (r *Registry) IncludesFor(ref string) (map[string]string, error) {
+	s := r.current.Load()
+	tmpl, err := s.lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+	return contents(s.scoped(tmpl.scope)), nil
+}
+
+// Render renders the template ref refers to with vars using its compiled
+// form. See Get for the forms of ref.
+func (r *Registry) Render(ref string, vars map[string]any) (engine.RenderResult, error) {
+	return r.RenderWithOptions(ref, vars, engine.Options{})
+}
+
+// RenderWithOptions renders a template like Render using opts.
+func (r *Registry) RenderWithOptions(ref string, vars map[string]any, opts engine.Options) (engine.RenderResult, error) {
+	tmpl, err := r.Get(ref)
+	if err != nil {
+		return engine.RenderResult{}, err
+	}
+	return tmpl.compiled.Execute(vars, opts)
+}
+
+// RenderMessages renders the template ref refers to and splits the output
+// into chat messages.
+func (r *Registry) RenderMessages(ref string, vars map[string]any) (engine.RenderResult, error) {
+	return r.RenderMessagesWithOptions(ref, vars, engine.Options{})
+}
+
+// RenderMessagesWithOptions renders a template like RenderMessages using
+// opts.
+func (r *Registry) RenderMessagesWithOptions(ref string, vars map[string]any, opts engine.Options) (engine.RenderResult, error) {
+	tmpl, err := r.Get(ref)
+	if err != nil {
+		return engine.RenderResult{}, err
+	}
+	return tmpl.compiled.ExecuteMessages(vars, opts)
 }
@@ -1,9 +1,15 @@
 package registry
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+
+	"github.com/devaloi/promptkit/internal/engine"
 )
 
 func setupTestDir(t *testing.T) string {
@@ -114,3 +120,512 @@ func TestRegistry_PlainTemplate(t *testing.T) {
 		t.Errorf("expected empty meta name for plain template, got %q", tmpl.Meta.Name)
 	}
 }
+
+func TestRegistry_Render(t *testing.T) {
+	dir := setupTestDir(t)
+	reg := New()
+
+	if err := reg.LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir error: %v", err)
+	}
+
+	result, err := reg.Render("greet", map[string]any{"name": "Ada"})
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if result.Output != "Hello, Ada!" {
+		t.Errorf("unexpected output: %q", result.Output)
+	}
+
+	if _, err := reg.Render("nonexistent", nil); err == nil {
+		t.Error("expected error for missing template")
+	}
+}
+
+func TestRegistry_RenderConcurrent(t *testing.T) {
+	dir := setupTestDir(t)
+	writeFile(t, filepath.Join(dir, "report.tmpl"), `---
+name: report
+---
+{{ template "header" }} {{ .name | upper }}`)
+
+	reg := New()
+	if err := reg.LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opts := engine.Options{Strict: i%2 == 0}
+			result, err := reg.RenderWithOptions("report", map[string]any{"name": "ada"}, opts)
+			if err != nil {
+				t.Errorf("Render error: %v", err)
+				return
+			}
+			if result.Output != "=== HEADER === ADA" {
+				t.Errorf("unexpected output: %q", result.Output)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRegistry_LoadDirCompileError(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "broken.tmpl"), "Hello, {{ .name ")
+
+	err := New().LoadDir(dir)
+	if err == nil {
+		t.Fatal("expected compile error")
+	}
+	if !strings.Contains(err.Error(), `compiling template "broken"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func benchmarkRegistry(b *testing.B) *Registry {
+	b.Helper()
+	dir := b.TempDir()
+	incDir := filepath.Join(dir, "includes")
+	if err := os.Mkdir(incDir, 0o755); err != nil {
+		b.Fatal(err)
+	}
+	for _, name := range []string{"persona", "rules", "format"} {
+		content := "{{ define \"" + name + "_detail\" }}" + strings.Repeat(name+" detail. ", 20) + "{{ end }}"
+		if err := os.WriteFile(filepath.Join(incDir, name+".tmpl"), []byte(content), 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	content := `---
+name: bench
+description: Benchmark template
+required_vars:
+  - document
+---
+{{ system }}{{ template "persona_detail" }}
+{{ template "rules_detail" }}
+
+{{ user }}Summarize in {{ .max_words }} words:
+
+{{ .document | truncate 200 }}
+{{ template "format_detail" }}`
+	if err := os.WriteFile(filepath.Join(dir, "bench.tmpl"), []byte(content), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	reg := New()
+	if err := reg.LoadDir(dir); err != nil {
+		b.Fatal(err)
+	}
+	return reg
+}
+
+var benchVars = map[string]any{
+	"document":  strings.Repeat("Lorem ipsum dolor sit amet. ", 10),
+	"max_words": 100,
+}
+
+// BenchmarkRender_Uncached measures the engine.Render path, which parses the
+// template and every include on each call.
+func BenchmarkRender_Uncached(b *testing.B) {
+	reg := benchmarkRegistry(b)
+	tmpl, err := reg.Get("bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := engine.Render(tmpl.Content, benchVars, reg.Includes()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRegistry_Render(b *testing.B) {
+	reg := benchmarkRegistry(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := reg.Render("bench", benchVars); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRegistry_RenderParallel(b *testing.B) {
+	reg := benchmarkRegistry(b)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := reg.Render("bench", benchVars); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestRegistry_LoadDirErrorKeepsTemplates(t *testing.T) {
+	dir := setupTestDir(t)
+	reg := New()
+	if err := reg.LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir error: %v", err)
+	}
+
+	broken := t.TempDir()
+	writeFile(t, filepath.Join(broken, "extra.tmpl"), "ok")
+	writeFile(t, filepath.Join(broken, "greet.tmpl"), "Hello, {{ .name ")
+
+	if err := reg.LoadDir(broken); err == nil {
+		t.Fatal("expected compile error")
+	}
+	if _, err := reg.Get("extra"); err == nil {
+		t.Error("expected failed load not to add templates")
+	}
+	if len(reg.List()) != 3 {
+		t.Errorf("expected 3 templates, got %d", len(reg.List()))
+	}
+}
+
+func TestRegistry_LoadFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"prompts/includes/sig.tmpl": {Data: []byte("-- team")},
+		"prompts/greet.tmpl":        {Data: []byte("Hello, {{ .name }}! {{ template \"sig\" }}")},
+		"prompts/notes.txt":         {Data: []byte("ignored")},
+		"other/skip.tmpl":           {Data: []byte("not loaded")},
+	}
+
+	reg := New()
+	if err := reg.LoadFS(fsys, "prompts"); err != nil {
+		t.Fatalf("LoadFS error: %v", err)
+	}
+
+	if len(reg.List()) != 1 {
+		t.Errorf("expected 1 template, got %d", len(reg.List()))
+	}
+	result, err := reg.Render("greet", map[string]any{"name": "Ada"})
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if result.Output != "Hello, Ada! -- team" {
+		t.Errorf("unexpected output: %q", result.Output)
+	}
+
+	if err := reg.LoadFS(fsys, "missing"); err == nil {
+		t.Error("expected error for missing root")
+	}
+}
+
+func TestRegistry_Namespaces(t *testing.T) {
+	fsys := fstest.MapFS{
+		"includes/tone.tmpl":                       {Data: []byte("friendly")},
+		"includes/sig.tmpl":                        {Data: []byte("-- team")},
+		"support/includes/tone.tmpl":               {Data: []byte("calm")},
+		"support/triage/classify.tmpl":             {Data: []byte(`{{ template "tone" }} {{ template "sig" }}`)},
+		"support/escalate.tmpl":                    {Data: []byte("---\nname: urgent\n---\n{{ template \"tone\" }}")},
+		"sales/pitch.tmpl":                         {Data: []byte(`{{ template "tone" }}`)},
+		"support/triage/includes/nested/skip.tmpl": {Data: []byte("ignored")},
+	}
+
+	reg := New()
+	if err := reg.LoadFS(fsys, "."); err != nil {
+		t.Fatalf("LoadFS error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"support/triage/classify", "calm -- team"},
+		{"support/urgent", "calm"},
+		{"sales/pitch", "friendly"},
+	}
+	for _, tt := range tests {
+		result, err := reg.Render(tt.name, nil)
+		if err != nil {
+			t.Errorf("Render(%q) error: %v", tt.name, err)
+			continue
+		}
+		if result.Output != tt.want {
+			t.Errorf("Render(%q) = %q, want %q", tt.name, result.Output, tt.want)
+		}
+	}
+
+	if len(reg.List()) != 3 {
+		t.Errorf("expected 3 templates, got %d", len(reg.List()))
+	}
+
+	tmpl, err := reg.Get("support/triage/classify")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if tmpl.Path != "support/triage/classify.tmpl" {
+		t.Errorf("unexpected path: %q", tmpl.Path)
+	}
+
+	includes, err := reg.IncludesFor("support/triage/classify")
+	if err != nil {
+		t.Fatalf("IncludesFor error: %v", err)
+	}
+	if includes["tone"] != "calm" || includes["sig"] != "-- team" {
+		t.Errorf("unexpected scoped includes: %v", includes)
+	}
+	if reg.Includes()["tone"] != "friendly" {
+		t.Errorf("unexpected root includes: %v", reg.Includes())
+	}
+}
+
+func TestRegistry_Extends(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.tmpl": {Data: []byte("---\nrequired_vars: [task]\nmodel_hint: gpt-4\n---\n{{ block \"intro\" . }}Hi.{{ end }} {{ .task }}")},
+		"support/triage.tmpl": {Data: []byte("---\nextends: base\nrequired_vars: [ticket]\n---\n" +
+			"{{ define \"intro\" }}Ticket {{ .ticket }}.{{ end }}")},
+		"loop/a.tmpl":    {Data: []byte("---\nextends: b\n---\n")},
+		"loop/b.tmpl":    {Data: []byte("---\nextends: a\n---\n")},
+		"orphan.tmpl":    {Data: []byte("---\nextends: missing\n---\n")},
+		"overrides.tmpl": {Data: []byte("---\nextends: base\n---\n{{ define \"outro\" }}Bye{{ end }}")},
+	}
+
+	reg := NewWithOptions(Options{Lenient: true})
+	if err := reg.LoadFS(fsys, "."); err != nil {
+		t.Fatalf("LoadFS error: %v", err)
+	}
+
+	tmpl, err := reg.Get("support/triage")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if strings.Join(tmpl.Meta.RequiredVars, ",") != "task,ticket" || tmpl.Meta.ModelHint != "gpt-4" {
+		t.Errorf("expected inherited metadata, got %+v", tmpl.Meta)
+	}
+
+	result, err := reg.Render("support/triage", map[string]any{"task": "triage", "ticket": 42})
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if result.Output != "Ticket 42. triage" {
+		t.Errorf("unexpected output: %q", result.Output)
+	}
+
+	lineage, err := reg.Lineage("support/triage")
+	if err != nil {
+		t.Fatalf("Lineage error: %v", err)
+	}
+	if len(lineage) != 2 || lineage[0].Name != "base" {
+		t.Errorf("unexpected lineage: %v", lineage)
+	}
+
+	problems := make(map[string]string)
+	for _, d := range reg.Diagnostics() {
+		problems[d.Name] = d.Err.Error()
+	}
+	want := map[string]string{
+		"loop/a":    "extends cycle: loop/a -> loop/b -> loop/a",
+		"loop/b":    "extends cycle: loop/b -> loop/a -> loop/b",
+		"orphan":    `extends unknown template "missing"`,
+		"overrides": `overrides block "outro", which "base" does not define`,
+	}
+	for name, msg := range want {
+		if !strings.Contains(problems[name], msg) {
+			t.Errorf("%s: expected diagnostic containing %q, got %q", name, msg, problems[name])
+		}
+	}
+}
+
+func TestRegistry_IncludeFrontmatter(t *testing.T) {
+	fsys := fstest.MapFS{
+		"includes/persona.tmpl": {Data: []byte("---\ndescription: Sets the persona\nrequired_vars: [persona]\n---\nYou are {{ .persona }}. {{ template \"tone\" . }}")},
+		"includes/tone.tmpl":    {Data: []byte("---\nrequired_vars: [tone]\n---\nBe {{ .tone }}.")},
+		"includes/footer.tmpl":  {Data: []byte("---\nrequired_vars: [signature]\n---\n{{ .signature }}")},
+		"ask.tmpl":              {Data: []byte("---\nrequired_vars: [question]\n---\n{{ template \"persona\" . }}\n{{ .question }}")},
+		"signed.tmpl":           {Data: []byte("---\nrequired_vars: [letter]\n---\n{{ with .letter }}{{ template \"footer\" . }}{{ end }}")},
+		"plain.tmpl":            {Data: []byte("No includes.")},
+	}
+
+	reg := New()
+	if err := reg.LoadFS(fsys, "."); err != nil {
+		t.Fatalf("LoadFS error: %v", err)
+	}
+
+	ask, err := reg.Get("ask")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if got := strings.Join(ask.Meta.RequiredVars, ","); got != "question,persona,tone" {
+		t.Errorf("unexpected required vars: %s", got)
+	}
+	if got := strings.Join(ask.IncludeVars, ","); got != "persona,tone" {
+		t.Errorf("unexpected include vars: %s", got)
+	}
+	if got := strings.Join(ask.Declared().RequiredVars, ","); got != "question" {
+		t.Errorf("unexpected declared vars: %s", got)
+	}
+	// footer is rendered with .letter, not the root data.
+	signed, _ := reg.Get("signed")
+	if got := strings.Join(signed.Meta.RequiredVars, ","); got != "letter" {
+		t.Errorf("unexpected required vars for signed: %s", got)
+	}
+	plain, _ := reg.Get("plain")
+	if len(plain.Meta.RequiredVars) != 0 {
+		t.Errorf("unexpected required vars for plain: %v", plain.Meta.RequiredVars)
+	}
+
+	result, err := reg.Render("ask", map[string]any{"persona": "a tutor", "tone": "patient", "question": "Why?"})
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if result.Output != "You are a tutor. Be patient.\nWhy?" {
+		t.Errorf("unexpected output: %q", result.Output)
+	}
+	if got := strings.Join(result.Meta.RequiredVars, ","); got != "question,persona,tone" {
+		t.Errorf("unexpected rendered required vars: %s", got)
+	}
+
+	inc, err := reg.GetInclude("ask", "persona")
+	if err != nil {
+		t.Fatalf("GetInclude error: %v", err)
+	}
+	if inc.Meta.Description != "Sets the persona" || inc.Path != "includes/persona.tmpl" || inc.Scope != "." {
+		t.Errorf("unexpected include: %+v", inc)
+	}
+	if strings.Contains(inc.Body, "---") {
+		t.Errorf("include body still has frontmatter: %q", inc.Body)
+	}
+	if _, err := reg.GetInclude("ask", "missing"); err == nil {
+		t.Error("expected error for missing include")
+	}
+
+	var paths []string
+	for _, inc := range reg.ListIncludes() {
+		paths = append(paths, inc.Path)
+	}
+	if got := strings.Join(paths, ","); got != "includes/footer.tmpl,includes/persona.tmpl,includes/tone.tmpl" {
+		t.Errorf("unexpected includes: %s", got)
+	}
+}
+
+func TestRegistry_IncludeFrontmatterMalformed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"includes/bad.tmpl": {Data: []byte("---\nrequired_vars: [a\n---\nbody")},
+		"main.tmpl":         {Data: []byte("Hello")},
+	}
+
+	err := New().LoadFS(fsys, ".")
+	var loadErr *LoadError
+	if !errors.As(err, &loadErr) {
+		t.Fatalf("expected *LoadError, got %v", err)
+	}
+	if len(loadErr.Diagnostics) != 1 || loadErr.Diagnostics[0].Kind != KindFrontmatter || loadErr.Diagnostics[0].Path != "includes/bad.tmpl" {
+		t.Errorf("unexpected diagnostics: %v", loadErr)
+	}
+}
+
+func TestRegistry_Versions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"summarize_v1.tmpl":   {Data: []byte("---\nname: summarize\nversion: 1.0.0\n---\none")},
+		"summarize_v1_2.tmpl": {Data: []byte("---\nname: summarize\nversion: v1.2.0\n---\none-two")},
+		"summarize_v2.tmpl":   {Data: []byte("---\nname: summarize\nversion: 2.0.0\n---\ntwo")},
+		"summarize_v3.tmpl":   {Data: []byte("---\nname: summarize\nversion: 3.0.0-beta.1\n---\nthree")},
+		"base.tmpl":           {Data: []byte("---\nversion: 1.0.0\n---\n{{ block \"x\" . }}base one{{ end }}")},
+		"base2.tmpl":          {Data: []byte("---\nname: base\nversion: 2.0.0\nextends: base@1.0.0\n---\n{{ define \"x\" }}base two{{ end }}")},
+		"plain.tmpl":          {Data: []byte("plain")},
+	}
+
+	reg := New()
+	if err := reg.LoadFS(fsys, "."); err != nil {
+		t.Fatalf("LoadFS error: %v", err)
+	}
+
+	tests := []struct {
+		ref, want string
+	}{
+		{"summarize", "summarize@2.0.0"},
+		{"summarize@1.2.0", "summarize@1.2.0"},
+		{"summarize@v1.2.0", "summarize@1.2.0"},
+		{"summarize@^1", "summarize@1.2.0"},
+		{"summarize@~1.0", "summarize@1.0.0"},
+		{"summarize@>=2.0.0-beta", "summarize@2.0.0"},
+		{"summarize@3.0.0-beta.1", "summarize@3.0.0-beta.1"},
+		{"summarize@*", "summarize@2.0.0"},
+		{"base", "base@2.0.0"},
+		{"plain", "plain"},
+	}
+	for _, tt := range tests {
+		tmpl, err := reg.Get(tt.ref)
+		if err != nil {
+			t.Errorf("Get(%q) error: %v", tt.ref, err)
+			continue
+		}
+		if tmpl.Ref() != tt.want {
+			t.Errorf("Get(%q) = %s, want %s", tt.ref, tmpl.Ref(), tt.want)
+		}
+	}
+
+	for ref, msg := range map[string]string{
+		"summarize@^4":    `no version of template "summarize" matches "^4"`,
+		"missing@1.0.0":   `template "missing" not found`,
+		"summarize@>=x.y": "invalid",
+		"plain@1.0.0":     `no version of template "plain"`,
+	} {
+		if _, err := reg.Get(ref); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("Get(%q): expected error containing %q, got %v", ref, msg, err)
+		}
+	}
+
+	var versions []string
+	for _, tmpl := range reg.Versions("summarize") {
+		versions = append(versions, tmpl.Version)
+	}
+	if got := strings.Join(versions, ","); got != "3.0.0-beta.1,2.0.0,1.2.0,1.0.0" {
+		t.Errorf("unexpected versions: %s", got)
+	}
+	if n := len(reg.List()); n != 7 {
+		t.Errorf("expected 7 templates, got %d", n)
+	}
+
+	result, err := reg.Render("summarize@1", nil)
+	if err != nil || result.Output != "one-two" {
+		t.Errorf("Render(summarize@1) = %q, %v", result.Output, err)
+	}
+	for ref, want := range map[string]string{"base": "base two", "base@1.0.0": "base one"} {
+		result, err := reg.Render(ref, nil)
+		if err != nil || result.Output != want {
+			t.Errorf("Render(%s) = %q, %v; want %q", ref, result.Output, err, want)
+		}
+	}
+}
+
+func TestRegistry_VersionDiagnostics(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.tmpl":     {Data: []byte("---\nname: greet\nversion: 1.0.0\n---\na")},
+		"b.tmpl":     {Data: []byte("---\nname: greet\nversion: v1.0.0\n---\nb")},
+		"bad.tmpl":   {Data: []byte("---\nversion: 1.0\n---\nbad")},
+		"greet.tmpl": {Data: []byte("unversioned")},
+	}
+
+	reg := NewWithOptions(Options{Lenient: true})
+	if err := reg.LoadFS(fsys, "."); err != nil {
+		t.Fatalf("LoadFS error: %v", err)
+	}
+	byPath := make(map[string]*Diagnostic)
+	for _, d := range reg.Diagnostics() {
+		byPath[d.Path] = d
+	}
+	if d := byPath["bad.tmpl"]; d == nil || d.Kind != KindVersion {
+		t.Errorf("expected version diagnostic for bad.tmpl, got %v", d)
+	}
+	if d := byPath["b.tmpl"]; d == nil || d.Kind != KindDuplicateName || d.Other != "a.tmpl" {
+		t.Errorf("expected duplicate diagnostic for b.tmpl, got %v", d)
+	}
+	if d := byPath["greet.tmpl"]; d == nil || d.Kind != KindVersion || d.Other != "a.tmpl" {
+		t.Errorf("expected version diagnostic for greet.tmpl, got %v", d)
+	}
+
+	if tmpl, err := reg.Get("greet"); err != nil || tmpl.Path != "a.tmpl" {
+		t.Errorf("Get(greet) = %v, %v; want a.tmpl", tmpl, err)
+	}
+	if n := len(reg.List()); n != 1 {
+		t.Errorf("expected 1 template, got %d", n)
+	}
+}
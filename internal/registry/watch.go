@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ReloadEvent reports the outcome of a reload triggered by Watch.
+type ReloadEvent struct {
+	// Err is nil when the changed templates were swapped in. Otherwise the
+	// registry keeps serving the templates from the last successful load.
+	Err error
+}
+
+// Watch polls dir every interval and reloads the registry whenever a .tmpl
+// file below dir is added, removed or modified. A reload reads every layer the
+// registry was loaded from again, so a layered registry keeps its other
+// layers; an empty registry is loaded from dir. The new templates replace the
+// registry's contents only if they all load and compile.
+//
+// One event is sent per reload attempt, and one when dir cannot be scanned
+// until a scan fails differently or succeeds again. The caller must keep receiving from
+// the returned channel, which is closed once ctx is done.
+func (r *Registry) Watch(ctx context.Context, dir string, interval time.Duration) <-chan ReloadEvent {
+	events := make(chan ReloadEvent, 1)
+	last, _ := fingerprint(dir)
+	// scanErr is the message of the last error scanning dir, if the last scan
+	// failed.
+	var scanErr string
+
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			current, err := fingerprint(dir)
+			if err == nil && current == last {
+				continue
+			}
+			// A directory that stays unreadable is reported once, not on
+			// every tick.
+			if err != nil && err.Error() == scanErr {
+				continue
+			}
+			last, scanErr = current, ""
+			if err != nil {
+				scanErr = err.Error()
+			}
+
+			if err == nil {
+				err = r.reloadAll(dir)
+			}
+
+			select {
+			case events <- ReloadEvent{Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events
+}
+
+// reloadAll replaces the contents of the registry with the layers it was
+// loaded from, or with dir if it has none.
+func (r *Registry) reloadAll(dir string) error {
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+
+	if layers := r.current.Load().layers; len(layers) > 0 {
+		return r.swap(newSet(), layers)
+	}
+	if err := r.swap(newSet(), []Layer{{FS: os.DirFS(dir), Root: "."}}); err != nil {
+		return fmt.Errorf("loading %q: %w", dir, err)
+	}
+	return nil
+}
+
+// fingerprint summarizes the name, size and modification time of every
+// template file below dir.
+func fingerprint(dir string) (string, error) {
+	var b strings.Builder
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tmpl") {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", file, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("scanning %q: %w", dir, err)
+	}
+	return b.String(), nil
+}
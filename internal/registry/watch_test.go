@@ -0,0 +1,166 @@
+package registry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func nextEvent(t *testing.T, events <-chan ReloadEvent) ReloadEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for reload")
+		return ReloadEvent{}
+	}
+}
+
+func TestRegistry_Watch(t *testing.T) {
+	dir := setupTestDir(t)
+	reg := New()
+	if err := reg.LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := reg.Watch(ctx, dir, 10*time.Millisecond)
+
+	writeFile(t, filepath.Join(dir, "greet.tmpl"), `---
+name: greet
+---
+Hi there, {{ .name }}!`)
+
+	if ev := nextEvent(t, events); ev.Err != nil {
+		t.Fatalf("reload error: %v", ev.Err)
+	}
+	result, err := reg.Render("greet", map[string]any{"name": "Ada"})
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if result.Output != "Hi there, Ada!" {
+		t.Errorf("expected reloaded template, got %q", result.Output)
+	}
+
+	// A template that fails to compile keeps the previous set.
+	writeFile(t, filepath.Join(dir, "greet.tmpl"), "Hello, {{ .name ")
+
+	if ev := nextEvent(t, events); ev.Err == nil {
+		t.Fatal("expected reload error")
+	}
+	result, err = reg.Render("greet", map[string]any{"name": "Ada"})
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if result.Output != "Hi there, Ada!" {
+		t.Errorf("expected previous template, got %q", result.Output)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestRegistry_WatchLayered(t *testing.T) {
+	base := fstest.MapFS{
+		"bye.tmpl": {Data: []byte("Bye")},
+	}
+	dir := setupTestDir(t)
+	reg, err := Layered(
+		Layer{Name: "base", FS: base, Root: "."},
+		DirLayer(dir),
+	)
+	if err != nil {
+		t.Fatalf("Layered error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := reg.Watch(ctx, dir, 10*time.Millisecond)
+
+	writeFile(t, filepath.Join(dir, "greet.tmpl"), "Hi there, {{ .name }}!")
+
+	if ev := nextEvent(t, events); ev.Err != nil {
+		t.Fatalf("reload error: %v", ev.Err)
+	}
+	result, err := reg.Render("greet", map[string]any{"name": "Ada"})
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if result.Output != "Hi there, Ada!" {
+		t.Errorf("expected reloaded template, got %q", result.Output)
+	}
+	if _, err := reg.Get("bye"); err != nil {
+		t.Errorf("expected the base layer to survive the reload: %v", err)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestRegistry_WatchMissingDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "templates")
+	reg := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := reg.Watch(ctx, dir, 5*time.Millisecond)
+
+	if ev := nextEvent(t, events); ev.Err == nil {
+		t.Fatal("expected a scan error")
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("expected the scan error to be reported once, got another event: %v", ev.Err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "greet.tmpl"), "Hi")
+	if ev := nextEvent(t, events); ev.Err != nil {
+		t.Fatalf("reload error: %v", ev.Err)
+	}
+	if _, err := reg.Get("greet"); err != nil {
+		t.Errorf("expected the template once the directory exists: %v", err)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestRegistry_ReloadConcurrent(t *testing.T) {
+	dir := setupTestDir(t)
+	reg := New()
+	if err := reg.LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if _, err := reg.Render("greet", map[string]any{"name": "Ada"}); err != nil {
+					t.Errorf("Render error: %v", err)
+					return
+				}
+				reg.List()
+				_ = reg.Includes()["header"]
+			}
+		}()
+	}
+	for range 20 {
+		if err := reg.Reload(dir); err != nil {
+			t.Fatalf("Reload error: %v", err)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,164 @@
+package semver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constraint is a set of version ranges. A constraint is one or more ranges
+// separated by "||"; a range is one or more space-separated comparators that
+// must all match:
+//
+//	1.2.0          exactly 1.2.0 (also =1.2.0)
+//	1.2, 1.2.x     any 1.2 release
+//	^1.2.0         compatible with 1.2.0: >=1.2.0 <2.0.0
+//	~1.2.0         patch releases of 1.2: >=1.2.0 <1.3.0
+//	>=1.0.0 <2.0.0 comparisons with >, >=, < and <=
+//	*              any release
+//
+// Pre-release versions only match ranges with a comparator naming a
+// pre-release of the same MAJOR.MINOR.PATCH, so ^1.2.0 does not match
+// 1.3.0-beta.
+type Constraint struct {
+	text   string
+	ranges [][]comparator
+}
+
+type comparator struct {
+	op string
+	v  Version
+}
+
+// ParseConstraint parses a version constraint.
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{text: strings.TrimSpace(s)}
+	for _, part := range strings.Split(s, "||") {
+		var rng []comparator
+		for _, field := range strings.Fields(strings.ReplaceAll(part, ",", " ")) {
+			cmps, err := parseComparator(field)
+			if err != nil {
+				return Constraint{}, fmt.Errorf("invalid version constraint %q: %w", s, err)
+			}
+			rng = append(rng, cmps...)
+		}
+		c.ranges = append(c.ranges, rng)
+	}
+	return c, nil
+}
+
+// parseComparator expands a single comparator into primitive comparisons.
+func parseComparator(s string) ([]comparator, error) {
+	if s == "*" || s == "x" || s == "X" {
+		return nil, nil
+	}
+
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(s, prefix) {
+			op, s = prefix, s[len(prefix):]
+			break
+		}
+	}
+
+	v, parts, err := parsePartial(s)
+	if err != nil {
+		return nil, err
+	}
+	if parts == 0 {
+		if op == "" || op == "=" || op == ">=" || op == "<=" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%q matches no version", op+s)
+	}
+
+	switch op {
+	case "", "=":
+		if parts == 3 {
+			return []comparator{{"=", v}}, nil
+		}
+		return []comparator{{">=", v}, {"<", bump(v, parts)}}, nil
+	case ">=":
+		return []comparator{{">=", v}}, nil
+	case "<":
+		return []comparator{{"<", v}}, nil
+	case ">":
+		if parts == 3 {
+			return []comparator{{">", v}}, nil
+		}
+		return []comparator{{">=", bump(v, parts)}}, nil
+	case "<=":
+		if parts == 3 {
+			return []comparator{{"<=", v}}, nil
+		}
+		return []comparator{{"<", bump(v, parts)}}, nil
+	case "~":
+		return []comparator{{">=", v}, {"<", bump(v, min(parts, 2))}}, nil
+	default: // "^"
+		// Bump the first non-zero part, or the last part given.
+		switch {
+		case v.Major > 0 || parts == 1:
+			return []comparator{{">=", v}, {"<", bump(v, 1)}}, nil
+		case v.Minor > 0 || parts == 2:
+			return []comparator{{">=", v}, {"<", bump(v, 2)}}, nil
+		default:
+			return []comparator{{">=", v}, {"<", bump(v, 3)}}, nil
+		}
+	}
+}
+
+// bump returns the lowest release above every version whose first parts
+// numbers equal v's.
+func bump(v Version, parts int) Version {
+	switch parts {
+	case 1:
+		return Version{Major: v.Major + 1}
+	case 2:
+		return Version{Major: v.Major, Minor: v.Minor + 1}
+	default:
+		return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+	}
+}
+
+// Check reports whether v satisfies the constraint.
+func (c Constraint) Check(v Version) bool {
+	for _, rng := range c.ranges {
+		if matchRange(rng, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchRange(rng []comparator, v Version) bool {
+	allowPre := !v.Prerelease()
+	for _, cmp := range rng {
+		if !cmp.match(v) {
+			return false
+		}
+		if cmp.v.Prerelease() && cmp.v.sameRelease(v) {
+			allowPre = true
+		}
+	}
+	return allowPre
+}
+
+func (cmp comparator) match(v Version) bool {
+	c := v.Compare(cmp.v)
+	switch cmp.op {
+	case "=":
+		return c == 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	default: // "<="
+		return c <= 0
+	}
+}
+
+// String returns the constraint as it was written.
+func (c Constraint) String() string {
+	return c.text
+}
@@ -0,0 +1,156 @@
+// Package semver parses semantic versions and the version constraints used to
+// pin templates.
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version: MAJOR.MINOR.PATCH with an optional
+// pre-release. Build metadata is accepted and ignored.
+type Version struct {
+	Major, Minor, Patch int
+
+	// Pre holds the dot-separated pre-release identifiers, such as
+	// ["beta", "2"] for 1.0.0-beta.2.
+	Pre []string
+}
+
+// Parse parses a full semantic version, with an optional leading "v".
+func Parse(s string) (Version, error) {
+	v, parts, err := parsePartial(s)
+	if err != nil {
+		return Version{}, err
+	}
+	if parts < 3 {
+		return Version{}, fmt.Errorf("invalid version %q: want MAJOR.MINOR.PATCH", s)
+	}
+	return v, nil
+}
+
+// parsePartial parses a version that may omit the minor and patch numbers or
+// give them as "x" or "*". It returns the number of parts given.
+func parsePartial(s string) (Version, int, error) {
+	text := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	text, _, _ = strings.Cut(text, "+")
+	text, pre, hasPre := strings.Cut(text, "-")
+
+	var v Version
+	fields := strings.Split(text, ".")
+	if len(fields) > 3 || text == "" {
+		return Version{}, 0, fmt.Errorf("invalid version %q", s)
+	}
+
+	parts := 0
+	for i, f := range fields {
+		if f == "x" || f == "X" || f == "*" {
+			continue
+		}
+		if parts < i {
+			// A number follows a wildcard, as in 1.x.3.
+			return Version{}, 0, fmt.Errorf("invalid version %q", s)
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 || (len(f) > 1 && f[0] == '0') {
+			return Version{}, 0, fmt.Errorf("invalid version %q", s)
+		}
+		switch i {
+		case 0:
+			v.Major = n
+		case 1:
+			v.Minor = n
+		case 2:
+			v.Patch = n
+		}
+		parts++
+	}
+
+	if hasPre {
+		if parts < 3 || pre == "" {
+			return Version{}, 0, fmt.Errorf("invalid version %q", s)
+		}
+		v.Pre = strings.Split(pre, ".")
+		for _, id := range v.Pre {
+			if id == "" {
+				return Version{}, 0, fmt.Errorf("invalid version %q", s)
+			}
+		}
+	}
+	return v, parts, nil
+}
+
+// String returns the canonical form of v, without a leading "v".
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Pre) > 0 {
+		s += "-" + strings.Join(v.Pre, ".")
+	}
+	return s
+}
+
+// Prerelease reports whether v is a pre-release version.
+func (v Version) Prerelease() bool {
+	return len(v.Pre) > 0
+}
+
+// Compare returns -1, 0 or 1 as v is lower than, equal to or higher than o,
+// using semantic version precedence.
+func (v Version) Compare(o Version) int {
+	if c := compareInts(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareInts(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareInts(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+
+	// A pre-release is lower than the release itself.
+	switch {
+	case len(v.Pre) == 0 && len(o.Pre) == 0:
+		return 0
+	case len(v.Pre) == 0:
+		return 1
+	case len(o.Pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Pre) && i < len(o.Pre); i++ {
+		if c := comparePre(v.Pre[i], o.Pre[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(v.Pre), len(o.Pre))
+}
+
+// comparePre compares pre-release identifiers: numeric identifiers compare
+// numerically and are lower than alphanumeric ones.
+func comparePre(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInts(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (v Version) sameRelease(o Version) bool {
+	return v.Major == o.Major && v.Minor == o.Minor && v.Patch == o.Patch
+}
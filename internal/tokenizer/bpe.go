@@ -0,0 +1,156 @@
+package tokenizer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Pre-tokenization patterns of the tiktoken encodings. The trailing `\s+(?!\S)`
+// alternative of the originals uses a lookahead, which RE2 does not support;
+// it is emulated by BPE.pretokenize, so the patterns end in a capturing `(\s+)`.
+const (
+	PatternCL100K = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|(\s+)`
+	PatternO200K  = `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|(\s+)`
+)
+
+// BPE is a byte-level byte-pair-encoding tokenizer using tiktoken merge ranks,
+// as used by the cl100k_base and o200k_base encodings.
+type BPE struct {
+	ranks   map[string]int
+	pattern *regexp.Regexp
+}
+
+// NewBPE creates a BPE tokenizer from token ranks and a pre-tokenization
+// pattern. If the pattern's first capture group matches, the match is treated
+// as tiktoken's `\s+(?!\S)` alternative.
+func NewBPE(ranks map[string]int, pattern string) (*BPE, error) {
+	re, err := regexp.Compile(`^(?:` + pattern + `)`)
+	if err != nil {
+		return nil, fmt.Errorf("compiling pattern: %w", err)
+	}
+	return &BPE{ranks: ranks, pattern: re}, nil
+}
+
+// LoadTiktoken loads a tiktoken rank file ("<base64 token> <rank>" per line).
+// Files whose name contains "o200k" use PatternO200K; all others use
+// PatternCL100K.
+func LoadTiktoken(path string) (*BPE, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening tiktoken file: %w", err)
+	}
+	defer f.Close()
+
+	pattern := PatternCL100K
+	if strings.Contains(filepath.Base(path), "o200k") {
+		pattern = PatternO200K
+	}
+	return ParseTiktoken(f, pattern)
+}
+
+// ParseTiktoken reads tiktoken ranks from r and creates a BPE tokenizer.
+func ParseTiktoken(r io.Reader, pattern string) (*BPE, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		token, rank, ok := strings.Cut(text, " ")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"<token> <rank>\"", line)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: decoding token: %w", line, err)
+		}
+		n, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: parsing rank: %w", line, err)
+		}
+		ranks[string(decoded)] = n
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading tiktoken ranks: %w", err)
+	}
+	return NewBPE(ranks, pattern)
+}
+
+// Count returns the number of BPE tokens in text.
+func (b *BPE) Count(text string) int {
+	n := 0
+	for _, piece := range b.pretokenize(text) {
+		n += len(b.merge(piece))
+	}
+	return n
+}
+
+// Split returns the BPE tokens of text.
+func (b *BPE) Split(text string) []string {
+	var tokens []string
+	for _, piece := range b.pretokenize(text) {
+		tokens = append(tokens, b.merge(piece)...)
+	}
+	return tokens
+}
+
+// pretokenize splits text into the pieces that BPE merges operate within.
+func (b *BPE) pretokenize(text string) []string {
+	var pieces []string
+	for len(text) > 0 {
+		m := b.pattern.FindStringSubmatchIndex(text)
+		end := 0
+		if m != nil {
+			end = m[1]
+		}
+		// Emulate `\s+(?!\S)`: a whitespace run followed by more text leaves
+		// its last character to prefix the next piece.
+		if m != nil && len(m) >= 4 && m[2] >= 0 && end < len(text) {
+			_, size := utf8.DecodeLastRuneInString(text[:end])
+			if end-size > 0 {
+				end -= size
+			}
+		}
+		if end == 0 {
+			_, end = utf8.DecodeRuneInString(text)
+		}
+		pieces = append(pieces, text[:end])
+		text = text[end:]
+	}
+	return pieces
+}
+
+// merge applies byte-pair merges to piece in rank order.
+func (b *BPE) merge(piece string) []string {
+	if _, ok := b.ranks[piece]; ok {
+		return []string{piece}
+	}
+
+	parts := byteTokens(piece)
+	for len(parts) > 1 {
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i+1 < len(parts); i++ {
+			if rank, ok := b.ranks[parts[i]+parts[i+1]]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts[best] += parts[best+1]
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+	return parts
+}
@@ -0,0 +1,358 @@
+package tokenizer
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// SentencePiece model types, from sentencepiece_model.proto.
+const (
+	spUnigram = 1
+	spBPE     = 2
+)
+
+// SentencePiece piece types, from sentencepiece_model.proto.
+const (
+	spNormal      = 1
+	spUnknown     = 2
+	spUserDefined = 4
+	spByte        = 6
+)
+
+// spaceSymbol replaces spaces in SentencePiece vocabularies.
+const spaceSymbol = '▁'
+
+// SentencePiece tokenizes text with a SentencePiece unigram or BPE model.
+// Normalization rules stored in the model are not applied, so counts for text
+// with irregular whitespace or non-NFKC characters are approximate.
+type SentencePiece struct {
+	scores      map[string]float32
+	maxLen      int
+	minScore    float32
+	bpe         bool
+	byteTokens  bool
+	dummyPrefix bool
+}
+
+// LoadSentencePiece loads a SentencePiece ".model" file.
+func LoadSentencePiece(path string) (*SentencePiece, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading sentencepiece model: %w", err)
+	}
+	return ParseSentencePiece(data)
+}
+
+// ParseSentencePiece decodes a serialized SentencePiece ModelProto.
+func ParseSentencePiece(data []byte) (*SentencePiece, error) {
+	sp := &SentencePiece{
+		scores:      make(map[string]float32),
+		dummyPrefix: true,
+	}
+
+	modelType := spUnigram
+	err := walkProto(data, func(field int, value []byte, varint uint64) error {
+		switch field {
+		case 1: // pieces
+			return sp.addPiece(value)
+		case 2: // trainer_spec
+			return walkProto(value, func(field int, _ []byte, varint uint64) error {
+				if field == 3 { // model_type
+					modelType = int(varint)
+				}
+				return nil
+			})
+		case 3: // normalizer_spec
+			return walkProto(value, func(field int, _ []byte, varint uint64) error {
+				if field == 3 { // add_dummy_prefix
+					sp.dummyPrefix = varint != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decoding sentencepiece model: %w", err)
+	}
+
+	switch modelType {
+	case spUnigram:
+	case spBPE:
+		sp.bpe = true
+	default:
+		return nil, fmt.Errorf("unsupported sentencepiece model type %d", modelType)
+	}
+	if len(sp.scores) == 0 {
+		return nil, errors.New("sentencepiece model has no pieces")
+	}
+	return sp, nil
+}
+
+func (sp *SentencePiece) addPiece(data []byte) error {
+	var (
+		piece string
+		score float32
+		typ   = spNormal
+	)
+	err := walkProto(data, func(field int, value []byte, varint uint64) error {
+		switch field {
+		case 1:
+			piece = string(value)
+		case 2:
+			score = math.Float32frombits(uint32(varint))
+		case 3:
+			typ = int(varint)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	switch typ {
+	case spNormal, spUserDefined:
+		sp.scores[piece] = score
+		sp.maxLen = max(sp.maxLen, utf8.RuneCountInString(piece))
+		sp.minScore = min(sp.minScore, score)
+	case spByte:
+		sp.byteTokens = true
+	case spUnknown:
+	}
+	return nil
+}
+
+// Count returns the number of SentencePiece tokens in text.
+func (sp *SentencePiece) Count(text string) int {
+	return len(sp.Split(text))
+}
+
+// Split returns the SentencePiece tokens of text, mapped back onto the
+// original text. The first token does not include the dummy prefix.
+func (sp *SentencePiece) Split(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	normalized := []rune(strings.ReplaceAll(text, " ", string(spaceSymbol)))
+	original := []rune(text)
+	offset := 0
+	if sp.dummyPrefix {
+		normalized = append([]rune{spaceSymbol}, normalized...)
+		offset = 1
+	}
+
+	var lengths []int
+	if sp.bpe {
+		lengths = sp.mergeBPE(normalized)
+	} else {
+		lengths = sp.viterbi(normalized)
+	}
+
+	var tokens []string
+	pos := 0
+	for _, n := range lengths {
+		start := max(pos-offset, 0)
+		end := pos + n - offset
+		pos += n
+		piece := string(original[start:end])
+		if _, known := sp.scores[string(normalized[pos-n:pos])]; !known && sp.byteTokens {
+			tokens = append(tokens, byteTokens(piece)...)
+			continue
+		}
+		tokens = append(tokens, piece)
+	}
+	return tokens
+}
+
+// viterbi finds the highest-scoring segmentation of runes into known pieces
+// and returns the rune length of each piece. Runes not covered by any piece
+// become single-rune unknown tokens with a heavy penalty.
+func (sp *SentencePiece) viterbi(runes []rune) []int {
+	unkScore := float64(sp.minScore) - 10
+	best := make([]float64, len(runes)+1)
+	prev := make([]int, len(runes)+1)
+	for i := 1; i <= len(runes); i++ {
+		best[i] = math.Inf(-1)
+		for start := max(0, i-sp.maxLen); start < i; start++ {
+			if math.IsInf(best[start], -1) {
+				continue
+			}
+			score, ok := sp.scores[string(runes[start:i])]
+			if !ok {
+				continue
+			}
+			if s := best[start] + float64(score); s > best[i] {
+				best[i], prev[i] = s, start
+			}
+		}
+		if s := best[i-1] + unkScore; math.IsInf(best[i], -1) || s > best[i] {
+			best[i], prev[i] = s, i-1
+		}
+	}
+
+	var lengths []int
+	for i := len(runes); i > 0; i = prev[i] {
+		lengths = append(lengths, i-prev[i])
+	}
+	for l, r := 0, len(lengths)-1; l < r; l, r = l+1, r-1 {
+		lengths[l], lengths[r] = lengths[r], lengths[l]
+	}
+	return lengths
+}
+
+// mergeBPE splits runes into words before each space symbol, as SentencePiece
+// does, merges each word with mergeWord and returns the rune length of each
+// resulting symbol.
+func (sp *SentencePiece) mergeBPE(runes []rune) []int {
+	var lengths []int
+	start := 0
+	for i := 1; i <= len(runes); i++ {
+		if i == len(runes) || runes[i] == spaceSymbol {
+			lengths = sp.mergeWord(runes[start:i], lengths)
+			start = i
+		}
+	}
+	return lengths
+}
+
+// bpeSymbol is a symbol of a word being merged, linked to its neighbours by
+// rune index. n is its length in runes, or 0 once it is merged into the
+// symbol before it.
+type bpeSymbol struct {
+	prev, next, n int
+}
+
+// bpePair is a candidate merge of the symbols starting at left and right,
+// whose concatenation is n runes long and scores score.
+type bpePair struct {
+	left, right, n int
+	score          float32
+}
+
+// bpeQueue orders candidate merges by score, then leftmost first.
+type bpeQueue []bpePair
+
+func (q bpeQueue) Len() int { return len(q) }
+
+func (q bpeQueue) Less(i, j int) bool {
+	if q[i].score != q[j].score {
+		return q[i].score > q[j].score
+	}
+	return q[i].left < q[j].left
+}
+
+func (q bpeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *bpeQueue) Push(x any) { *q = append(*q, x.(bpePair)) }
+
+func (q *bpeQueue) Pop() any {
+	old := *q
+	p := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return p
+}
+
+// mergeWord repeatedly merges the adjacent pair of symbols of word whose
+// concatenation is the highest-scoring known piece, leftmost first, and
+// appends the rune length of each resulting symbol to lengths. Symbols form a
+// linked list and candidate pairs a priority queue, so each merge costs
+// O(log n); candidates made stale by an earlier merge are skipped.
+func (sp *SentencePiece) mergeWord(word []rune, lengths []int) []int {
+	symbols := make([]bpeSymbol, len(word))
+	for i := range word {
+		symbols[i] = bpeSymbol{prev: i - 1, next: i + 1, n: 1}
+	}
+
+	var queue bpeQueue
+	push := func(left, right int) {
+		if left < 0 || right >= len(word) {
+			return
+		}
+		n := symbols[left].n + symbols[right].n
+		if score, ok := sp.scores[string(word[left:left+n])]; ok {
+			heap.Push(&queue, bpePair{left: left, right: right, n: n, score: score})
+		}
+	}
+	for i := 1; i < len(word); i++ {
+		push(i-1, i)
+	}
+
+	for queue.Len() > 0 {
+		p := heap.Pop(&queue).(bpePair)
+		left, right := &symbols[p.left], &symbols[p.right]
+		if left.n == 0 || right.n == 0 || left.n+right.n != p.n {
+			continue
+		}
+		left.n, right.n = p.n, 0
+		left.next = right.next
+		if right.next < len(word) {
+			symbols[right.next].prev = p.left
+		}
+		push(left.prev, p.left)
+		push(p.left, left.next)
+	}
+
+	for i := 0; i < len(word); i = symbols[i].next {
+		lengths = append(lengths, symbols[i].n)
+	}
+	return lengths
+}
+
+// walkProto calls fn for each field of a protobuf message. Length-delimited
+// fields are passed as value and varint and fixed-width fields as varint.
+func walkProto(data []byte, fn func(field int, value []byte, varint uint64) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("malformed field key")
+		}
+		data = data[n:]
+		field := int(key >> 3)
+
+		var (
+			value  []byte
+			varint uint64
+		)
+		switch key & 7 {
+		case 0:
+			varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errors.New("malformed varint")
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return errors.New("truncated fixed64")
+			}
+			varint = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errors.New("truncated length-delimited field")
+			}
+			value = data[n : n+int(size)]
+			data = data[n+int(size):]
+		case 5:
+			if len(data) < 4 {
+				return errors.New("truncated fixed32")
+			}
+			varint = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d", key&7)
+		}
+
+		if err := fn(field, value, varint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
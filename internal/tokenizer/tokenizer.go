@@ -0,0 +1,96 @@
+// Package tokenizer counts and splits text into LLM tokens.
+package tokenizer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Tokenizer splits text into model tokens.
+type Tokenizer interface {
+	// Count returns the number of tokens in text.
+	Count(text string) int
+	// Split breaks text into consecutive tokens whose concatenation is text.
+	// A token may end in the middle of a multi-byte rune.
+	Split(text string) []string
+}
+
+// Heuristic estimates tokens at roughly four bytes per token. It is used when
+// no vocabulary is configured for a model.
+type Heuristic struct{}
+
+// Count estimates the number of tokens in text (~4 bytes per token).
+func (Heuristic) Count(text string) int {
+	n := len(text)
+	if n == 0 {
+		return 0
+	}
+	return (n + 3) / 4
+}
+
+// Split breaks text into chunks of at least four bytes, never splitting a rune.
+func (Heuristic) Split(text string) []string {
+	var chunks []string
+	start := 0
+	for i := range text {
+		if i-start >= 4 {
+			chunks = append(chunks, text[start:i])
+			start = i
+		}
+	}
+	if start < len(text) {
+		chunks = append(chunks, text[start:])
+	}
+	return chunks
+}
+
+// Set maps model names to tokenizers. A key matches any model name it is a
+// prefix of, so "gpt-4" covers "gpt-4-turbo"; the longest matching key wins
+// and the empty key matches every model.
+type Set map[string]Tokenizer
+
+// For returns the tokenizer for model, falling back to Heuristic when no key
+// matches.
+func (s Set) For(model string) Tokenizer {
+	best := -1
+	var tok Tokenizer = Heuristic{}
+	for prefix, t := range s {
+		if strings.HasPrefix(model, prefix) && len(prefix) > best {
+			best = len(prefix)
+			tok = t
+		}
+	}
+	return tok
+}
+
+// Load reads a vocabulary file from disk, choosing the format by extension:
+// ".tiktoken" files are byte-level BPE rank files and ".model" files are
+// SentencePiece models.
+func Load(path string) (Tokenizer, error) {
+	var (
+		tok Tokenizer
+		err error
+	)
+	switch filepath.Ext(path) {
+	case ".tiktoken":
+		tok, err = LoadTiktoken(path)
+	case ".model":
+		tok, err = LoadSentencePiece(path)
+	default:
+		return nil, fmt.Errorf("unknown tokenizer format %q (want .tiktoken or .model)", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+// byteTokens splits s into single-byte tokens.
+func byteTokens(s string) []string {
+	tokens := make([]string, len(s))
+	for i := range len(s) {
+		tokens[i] = s[i : i+1]
+	}
+	return tokens
+}
@@ -0,0 +1,238 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tiktokenFile builds a rank file in which every byte is a token and the
+// given merges follow in order.
+func tiktokenFile(merges ...string) []byte {
+	var b strings.Builder
+	rank := 0
+	for i := range 256 {
+		fmt.Fprintf(&b, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(i)}), rank)
+		rank++
+	}
+	for _, m := range merges {
+		fmt.Fprintf(&b, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(m)), rank)
+		rank++
+	}
+	return []byte(b.String())
+}
+
+func TestHeuristic(t *testing.T) {
+	tests := []struct {
+		input string
+		count int
+	}{
+		{"", 0},
+		{"hi", 1},
+		{"hello", 2},
+		{"1234567890123456", 4},
+	}
+	for _, tt := range tests {
+		if got := (Heuristic{}).Count(tt.input); got != tt.count {
+			t.Errorf("Count(%q) = %d, want %d", tt.input, got, tt.count)
+		}
+		if got := strings.Join((Heuristic{}).Split(tt.input), ""); got != tt.input {
+			t.Errorf("Split(%q) does not reassemble: %q", tt.input, got)
+		}
+	}
+
+	for _, chunk := range (Heuristic{}).Split("héllo wörld ünïcode") {
+		if !utf8.ValidString(chunk) {
+			t.Errorf("chunk %q splits a rune", chunk)
+		}
+	}
+}
+
+func TestBPE_Tiktoken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cl100k_base.tiktoken")
+	writeFile(t, path, tiktokenFile("he", "ll", "hell", "hello", " w", " wor", "or", " world"))
+
+	tok, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+
+	tokens := tok.Split("hello world")
+	if !slices.Equal(tokens, []string{"hello", " world"}) {
+		t.Errorf("unexpected tokens: %q", tokens)
+	}
+	if n := tok.Count("hello world!!"); n != 4 {
+		t.Errorf("expected 4 tokens, got %d", n)
+	}
+
+	// Whitespace before a word leaves its last space to prefix the word.
+	tokens = tok.Split("a   world")
+	if !slices.Equal(tokens, []string{"a", " ", " ", " world"}) {
+		t.Errorf("unexpected whitespace tokens: %q", tokens)
+	}
+
+	text := "日本語 and code: x := 42\n\n"
+	if got := strings.Join(tok.Split(text), ""); got != text {
+		t.Errorf("Split does not reassemble: %q", got)
+	}
+}
+
+func TestParseTiktoken_Malformed(t *testing.T) {
+	if _, err := ParseTiktoken(strings.NewReader("notbase64!! 1\n"), PatternCL100K); err == nil {
+		t.Error("expected error for invalid base64")
+	}
+	if _, err := ParseTiktoken(strings.NewReader("YQ==\n"), PatternCL100K); err == nil {
+		t.Error("expected error for missing rank")
+	}
+}
+
+// protoField encodes a length-delimited protobuf field.
+func protoField(field int, value []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(field<<3|2))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// protoVarint encodes a varint protobuf field.
+func protoVarint(field int, value uint64) []byte {
+	b := binary.AppendUvarint(nil, uint64(field<<3))
+	return binary.AppendUvarint(b, value)
+}
+
+// sentencePieceModel encodes a minimal ModelProto with the given pieces.
+func sentencePieceModel(modelType int, pieces map[string]float32) []byte {
+	var model []byte
+	model = append(model, protoField(1, append(protoField(1, []byte("<unk>")), protoVarint(3, spUnknown)...))...)
+
+	names := make([]string, 0, len(pieces))
+	for p := range pieces {
+		names = append(names, p)
+	}
+	slices.Sort(names)
+	for _, p := range names {
+		score := binary.AppendUvarint(nil, uint64(2<<3|5))
+		score = binary.LittleEndian.AppendUint32(score, math.Float32bits(pieces[p]))
+		model = append(model, protoField(1, append(protoField(1, []byte(p)), score...))...)
+	}
+	return append(model, protoField(2, protoVarint(3, uint64(modelType)))...)
+}
+
+func TestSentencePiece_Unigram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "llama.model")
+	writeFile(t, path, sentencePieceModel(spUnigram, map[string]float32{
+		"▁":      -5,
+		"▁hello": -1,
+		"▁world": -1,
+		"▁wor":   -2,
+		"ld":     -2,
+		"h":      -4,
+		"e":      -4,
+		"l":      -4,
+		"o":      -4,
+	}))
+
+	tok, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+
+	tokens := tok.Split("hello world")
+	if !slices.Equal(tokens, []string{"hello", " world"}) {
+		t.Errorf("unexpected tokens: %q", tokens)
+	}
+
+	// Unknown runes become single-rune tokens.
+	tokens = tok.Split("hello 日")
+	if !slices.Equal(tokens, []string{"hello", " ", "日"}) {
+		t.Errorf("unexpected tokens with unknown rune: %q", tokens)
+	}
+}
+
+func TestSentencePiece_BPE(t *testing.T) {
+	model := sentencePieceModel(spBPE, map[string]float32{
+		"▁": -1, "a": -1, "b": -1, "c": -1,
+		"ab": -2, "▁ab": -3, "abc": -4,
+	})
+
+	tok, err := ParseSentencePiece(model)
+	if err != nil {
+		t.Fatalf("ParseSentencePiece error: %v", err)
+	}
+
+	tokens := tok.Split("abc ab")
+	if !slices.Equal(tokens, []string{"ab", "c", " ab"}) {
+		t.Errorf("unexpected tokens: %q", tokens)
+	}
+}
+
+// BenchmarkSentencePiece_BPE measures BPE merges on prose and on a single
+// long word, which SentencePiece cannot split on spaces.
+func BenchmarkSentencePiece_BPE(b *testing.B) {
+	pieces := map[string]float32{"▁": 0}
+	for _, word := range strings.Fields("lorem ipsum dolor sit amet") {
+		word = "▁" + word
+		runes := []rune(word)
+		for i := range runes {
+			for j := i + 1; j <= len(runes); j++ {
+				pieces[string(runes[i:j])] = float32(j - i)
+			}
+		}
+	}
+	tok, err := ParseSentencePiece(sentencePieceModel(spBPE, pieces))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range []int{1 << 10, 8 << 10, 32 << 10} {
+		inputs := map[string]string{
+			"prose": strings.Repeat("lorem ipsum dolor sit amet ", size/27+1)[:size],
+			"word":  strings.Repeat("lorem", size/5+1)[:size],
+		}
+		for _, kind := range []string{"prose", "word"} {
+			b.Run(fmt.Sprintf("%s/%d", kind, size), func(b *testing.B) {
+				b.ReportAllocs()
+				for b.Loop() {
+					tok.Count(inputs[kind])
+				}
+			})
+		}
+	}
+}
+
+func TestLoad_UnknownFormat(t *testing.T) {
+	if _, err := Load("vocab.json"); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
+
+func TestSet_For(t *testing.T) {
+	gpt4 := Heuristic{}
+	set := Set{"gpt-4": gpt4, "gpt-4o": &BPE{}}
+
+	if _, ok := set.For("gpt-4o-mini").(*BPE); !ok {
+		t.Error("expected longest prefix gpt-4o to win")
+	}
+	if _, ok := set.For("gpt-4-turbo").(Heuristic); !ok {
+		t.Error("expected gpt-4 tokenizer")
+	}
+	if _, ok := set.For("claude").(Heuristic); !ok {
+		t.Error("expected heuristic fallback")
+	}
+	if _, ok := Set(nil).For("gpt-4").(Heuristic); !ok {
+		t.Error("expected heuristic fallback for nil set")
+	}
+}
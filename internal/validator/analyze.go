@@ -0,0 +1,323 @@
+package validator
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"text/template/parse"
+
+	"github.com/devaloi/promptkit/internal/frontmatter"
+)
+
+// Usage describes what a template body references.
+type Usage struct {
+	// Vars lists the top-level variables the template reads, sorted.
+	Vars []string
+	// Templates lists the templates invoked with {{ template }}, sorted.
+	Templates []string
+	// Rooted lists the templates invoked with the root variable map as their
+	// data, as in {{ template "name" . }}, sorted.
+	Rooted []string
+	// Includes lists the includes rendered with the include helper, sorted.
+	Includes []string
+}
+
+// LintResult reports mismatches between the variables a template declares in
+// its frontmatter and the variables its body references.
+type LintResult struct {
+	// Undeclared lists variables referenced but not declared.
+	Undeclared []string
+	// Unused lists declared variables that are never referenced.
+	Unused []string
+	// MissingTemplates lists invoked templates that are not defined.
+	MissingTemplates []string
+}
+
+// OK reports whether the lint found no problems.
+func (r LintResult) OK() bool {
+	return len(r.Undeclared) == 0 && len(r.Unused) == 0 && len(r.MissingTemplates) == 0
+}
+
+// Analyze walks the parse tree of body, following {{ template }} calls into
+// includes, and returns the top-level variables and templates it references.
+// References inside range and with blocks are relative to the new dot and are
+// not counted, except through $.
+func Analyze(body string, includes map[string]string) (Usage, error) {
+	return AnalyzeInherited([]string{body}, includes)
+}
+
+// AnalyzeInherited analyzes a template that extends others like Analyze.
+// bodies holds the body of each template in the extends chain, from the root
+// ancestor to the template itself.
+func AnalyzeInherited(bodies []string, includes map[string]string) (Usage, error) {
+	usage, _, err := analyze(bodies, includes)
+	return usage, err
+}
+
+// References returns the templates and includes that body invokes anywhere,
+// including inside its {{ define }} and {{ block }} sections. Unlike Analyze
+// it does not follow invoked templates, and templates that body defines
+// itself are not listed.
+func References(body string) (templates, includes []string, err error) {
+	trees := make(map[string]*parse.Tree)
+	if err := parseTree("main", body, trees); err != nil {
+		return nil, nil, fmt.Errorf("parsing template: %w", err)
+	}
+
+	w := &walker{
+		trees:     trees,
+		vars:      make(map[string]bool),
+		templates: make(map[string]bool),
+		includes:  make(map[string]bool),
+		visited:   make(map[visit]bool),
+	}
+	for name := range trees {
+		w.walkTemplate(name, true)
+	}
+	for name := range trees {
+		delete(w.templates, name)
+	}
+	return sortedKeys(w.templates), sortedKeys(w.includes), nil
+}
+
+// Lint compares the variables declared in meta (required_vars and vars) with
+// those referenced by body and its includes.
+func Lint(meta frontmatter.Metadata, body string, includes map[string]string) (LintResult, error) {
+	return LintInherited(meta, []string{body}, includes)
+}
+
+// LintInherited lints a template that extends others. bodies holds the body
+// of each template in the extends chain, from the root ancestor to the
+// template itself; blocks defined in later bodies replace those of the same
+// name in earlier ones.
+func LintInherited(meta frontmatter.Metadata, bodies []string, includes map[string]string) (LintResult, error) {
+	usage, trees, err := analyze(bodies, includes)
+	if err != nil {
+		return LintResult{}, err
+	}
+
+	declared := make(map[string]bool, len(meta.RequiredVars)+len(meta.Vars))
+	for _, name := range meta.RequiredVars {
+		declared[name] = true
+	}
+	for name := range meta.Vars {
+		declared[name] = true
+	}
+
+	var result LintResult
+	for _, name := range usage.Vars {
+		if !declared[name] {
+			result.Undeclared = append(result.Undeclared, name)
+		}
+	}
+	for _, name := range sortedKeys(declared) {
+		if !slices.Contains(usage.Vars, name) {
+			result.Unused = append(result.Unused, name)
+		}
+	}
+	for _, name := range append(usage.Templates, usage.Includes...) {
+		if _, ok := trees[name]; !ok && !slices.Contains(result.MissingTemplates, name) {
+			result.MissingTemplates = append(result.MissingTemplates, name)
+		}
+	}
+	sort.Strings(result.MissingTemplates)
+
+	return result, nil
+}
+
+// analyze parses includes and the first body into a tree set, overlays the
+// blocks of each later body, and walks the set from "main".
+func analyze(bodies []string, includes map[string]string) (Usage, map[string]*parse.Tree, error) {
+	trees := make(map[string]*parse.Tree)
+
+	names := make([]string, 0, len(includes))
+	for name := range includes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		text := includes[name]
+		if parsed, err := frontmatter.Parse(text); err == nil {
+			text = parsed.Body
+		}
+		if err := parseTree(name, text, trees); err != nil {
+			return Usage{}, nil, fmt.Errorf("parsing include %q: %w", name, err)
+		}
+	}
+	if err := parseTree("main", bodies[0], trees); err != nil {
+		return Usage{}, nil, fmt.Errorf("parsing template: %w", err)
+	}
+	for i, body := range bodies[1:] {
+		// Parse separately: redefining a block in one tree set is an error.
+		name := fmt.Sprintf("extends:%d", i+1)
+		overlay := make(map[string]*parse.Tree)
+		if err := parseTree(name, body, overlay); err != nil {
+			return Usage{}, nil, fmt.Errorf("parsing template: %w", err)
+		}
+		for n, tree := range overlay {
+			if n != name {
+				trees[n] = tree
+			}
+		}
+	}
+
+	w := &walker{
+		trees:     trees,
+		vars:      make(map[string]bool),
+		templates: make(map[string]bool),
+		includes:  make(map[string]bool),
+		visited:   make(map[visit]bool),
+	}
+	w.walkTemplate("main", true)
+
+	rooted := make(map[string]bool)
+	for v := range w.visited {
+		if v.rooted && v.name != "main" {
+			rooted[v.name] = true
+		}
+	}
+	return Usage{
+		Vars:      sortedKeys(w.vars),
+		Templates: sortedKeys(w.templates),
+		Rooted:    sortedKeys(rooted),
+		Includes:  sortedKeys(w.includes),
+	}, trees, nil
+}
+
+// parseTree parses text into trees without checking that functions exist, so
+// analysis does not depend on the engine's FuncMap.
+func parseTree(name, text string, trees map[string]*parse.Tree) error {
+	tree := parse.New(name)
+	tree.Mode = parse.SkipFuncCheck
+	_, err := tree.Parse(text, "", "", trees)
+	return err
+}
+
+// visit identifies a template walked with a given root binding, so recursive
+// templates terminate.
+type visit struct {
+	name   string
+	rooted bool
+}
+
+type walker struct {
+	trees     map[string]*parse.Tree
+	vars      map[string]bool
+	templates map[string]bool
+	includes  map[string]bool
+	visited   map[visit]bool
+}
+
+// walkTemplate walks the named template. rooted reports whether the data
+// passed to the template is the root variable map.
+func (w *walker) walkTemplate(name string, rooted bool) {
+	key := visit{name: name, rooted: rooted}
+	if w.visited[key] {
+		return
+	}
+	w.visited[key] = true
+
+	tree, ok := w.trees[name]
+	if !ok || tree.Root == nil {
+		return
+	}
+	w.walk(tree.Root, rooted, rooted)
+}
+
+// walk visits node. dot reports whether "." is the root map and dollar
+// whether "$" is.
+func (w *walker) walk(node parse.Node, dot, dollar bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			w.walk(child, dot, dollar)
+		}
+	case *parse.ActionNode:
+		w.walkPipe(n.Pipe, dot, dollar)
+	case *parse.IfNode:
+		w.walkPipe(n.Pipe, dot, dollar)
+		w.walk(n.List, dot, dollar)
+		w.walk(n.ElseList, dot, dollar)
+	case *parse.RangeNode:
+		w.walkPipe(n.Pipe, dot, dollar)
+		w.walk(n.List, false, dollar)
+		w.walk(n.ElseList, dot, dollar)
+	case *parse.WithNode:
+		w.walkPipe(n.Pipe, dot, dollar)
+		w.walk(n.List, false, dollar)
+		w.walk(n.ElseList, dot, dollar)
+	case *parse.TemplateNode:
+		w.templates[n.Name] = true
+		w.walkPipe(n.Pipe, dot, dollar)
+		w.walkTemplate(n.Name, dot && isDot(n.Pipe))
+	}
+}
+
+func (w *walker) walkPipe(pipe *parse.PipeNode, dot, dollar bool) {
+	if pipe == nil {
+		return
+	}
+	for _, cmd := range pipe.Cmds {
+		if name, ok := includeCall(cmd); ok {
+			w.includes[name] = true
+		}
+		for _, arg := range cmd.Args {
+			w.walkArg(arg, dot, dollar)
+		}
+	}
+}
+
+// includeCall returns the include named by an {{ include "name" ... }}
+// command.
+func includeCall(cmd *parse.CommandNode) (string, bool) {
+	if len(cmd.Args) < 2 {
+		return "", false
+	}
+	if fn, ok := cmd.Args[0].(*parse.IdentifierNode); !ok || fn.Ident != "include" {
+		return "", false
+	}
+	name, ok := cmd.Args[1].(*parse.StringNode)
+	if !ok {
+		return "", false
+	}
+	return name.Text, true
+}
+
+func (w *walker) walkArg(arg parse.Node, dot, dollar bool) {
+	switch n := arg.(type) {
+	case *parse.FieldNode:
+		if dot {
+			w.vars[n.Ident[0]] = true
+		}
+	case *parse.VariableNode:
+		if dollar && n.Ident[0] == "$" && len(n.Ident) > 1 {
+			w.vars[n.Ident[1]] = true
+		}
+	case *parse.ChainNode:
+		w.walkArg(n.Node, dot, dollar)
+	case *parse.PipeNode:
+		w.walkPipe(n, dot, dollar)
+	}
+}
+
+// isDot reports whether pipe is exactly ".".
+func isDot(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Decl) > 0 || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	_, ok := pipe.Cmds[0].Args[0].(*parse.DotNode)
+	return ok
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,173 @@
+package validator
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/devaloi/promptkit/internal/frontmatter"
+)
+
+func TestAnalyze_Vars(t *testing.T) {
+	body := `{{ .document | truncate 100 }}
+{{ if .verbose }}{{ .details.summary }}{{ end }}
+{{ range .items }}{{ .name }} {{ $.prefix }}{{ end }}
+{{ with .user }}{{ .email }}{{ else }}{{ .fallback }}{{ end }}
+{{ template "footer" . }}
+{{ template "scoped" .user }}`
+
+	includes := map[string]string{
+		"footer": "{{ .signature }}",
+		"scoped": "{{ .ignored }}{{ $.also_ignored }}",
+	}
+
+	usage, err := Analyze(body, includes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"details", "document", "fallback", "items", "prefix", "signature", "user", "verbose"}
+	if !slices.Equal(usage.Vars, expected) {
+		t.Errorf("expected vars %v, got %v", expected, usage.Vars)
+	}
+	if !slices.Equal(usage.Templates, []string{"footer", "scoped"}) {
+		t.Errorf("unexpected templates: %v", usage.Templates)
+	}
+}
+
+func TestAnalyze_RecursiveInclude(t *testing.T) {
+	includes := map[string]string{
+		"loop": `{{ .x }}{{ if .again }}{{ template "loop" . }}{{ end }}`,
+	}
+
+	usage, err := Analyze(`{{ template "loop" . }}`, includes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(usage.Vars, []string{"again", "x"}) {
+		t.Errorf("unexpected vars: %v", usage.Vars)
+	}
+}
+
+func TestAnalyze_ParseError(t *testing.T) {
+	if _, err := Analyze("{{ .foo ", nil); err == nil {
+		t.Fatal("expected parse error")
+	}
+}
+
+func TestLint(t *testing.T) {
+	meta := frontmatter.Metadata{
+		RequiredVars: []string{"document", "max_words"},
+		Vars: map[string]frontmatter.VarSpec{
+			"tone": {Type: frontmatter.TypeString},
+		},
+	}
+	body := `{{ .documnet }} {{ .tone }} {{ template "header" }} {{ template "missing" }}`
+
+	result, err := Lint(meta, body, map[string]string{"header": "=="})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !slices.Equal(result.Undeclared, []string{"documnet"}) {
+		t.Errorf("unexpected undeclared: %v", result.Undeclared)
+	}
+	if !slices.Equal(result.Unused, []string{"document", "max_words"}) {
+		t.Errorf("unexpected unused: %v", result.Unused)
+	}
+	if !slices.Equal(result.MissingTemplates, []string{"missing"}) {
+		t.Errorf("unexpected missing templates: %v", result.MissingTemplates)
+	}
+	if result.OK() {
+		t.Error("expected lint problems")
+	}
+}
+
+func TestLint_Clean(t *testing.T) {
+	meta := frontmatter.Metadata{RequiredVars: []string{"name"}}
+
+	result, err := Lint(meta, "Hello, {{ .name }}!", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.OK() {
+		t.Errorf("expected clean lint, got %+v", result)
+	}
+}
+
+func TestLintInherited(t *testing.T) {
+	bodies := []string{
+		`{{ block "intro" . }}{{ .greeting }}{{ end }} {{ .task }}`,
+		`{{ define "intro" }}Ticket {{ .ticket }}{{ end }}`,
+	}
+	meta := frontmatter.Metadata{RequiredVars: []string{"task", "ticket", "greeting"}}
+
+	result, err := LintInherited(meta, bodies, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Undeclared) != 0 {
+		t.Errorf("unexpected undeclared: %v", result.Undeclared)
+	}
+	if !slices.Equal(result.Unused, []string{"greeting"}) {
+		t.Errorf("expected the overridden block's variable to be unused, got %v", result.Unused)
+	}
+}
+
+func TestLint_IncludeCalls(t *testing.T) {
+	body := `{{ include "fields" "fields" .keys }}{{ include "missing" }}`
+	includes := map[string]string{"fields": "{{ .fields }}"}
+	meta := frontmatter.Metadata{RequiredVars: []string{"keys"}}
+
+	result, err := Lint(meta, body, includes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Undeclared) != 0 || len(result.Unused) != 0 {
+		t.Errorf("unexpected variable problems: %+v", result)
+	}
+	if !slices.Equal(result.MissingTemplates, []string{"missing"}) {
+		t.Errorf("expected missing include, got %v", result.MissingTemplates)
+	}
+}
+
+func TestAnalyze_TemplatesAndIncludes(t *testing.T) {
+	body := `{{ template "header" . }}{{ include "fields" }}`
+	includes := map[string]string{
+		"header": "---\ndescription: \"{{ not a template }}\"\n---\n{{ template \"footer\" }}",
+		"footer": "--",
+		"fields": "{{ .fields }}",
+	}
+
+	usage, err := Analyze(body, includes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(usage.Templates, []string{"footer", "header"}) {
+		t.Errorf("unexpected templates: %v", usage.Templates)
+	}
+	if !slices.Equal(usage.Rooted, []string{"header"}) {
+		t.Errorf("unexpected rooted templates: %v", usage.Rooted)
+	}
+	if !slices.Equal(usage.Includes, []string{"fields"}) {
+		t.Errorf("unexpected includes: %v", usage.Includes)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	body := `{{ define "header" }}{{ template "tone" . }}{{ end }}{{ block "content" . }}{{ include "fields" }}{{ end }}{{ template "header" . }}{{ template "footer" }}`
+
+	templates, includes, err := References(body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(templates, []string{"footer", "tone"}) {
+		t.Errorf("unexpected templates: %v", templates)
+	}
+	if !slices.Equal(includes, []string{"fields"}) {
+		t.Errorf("unexpected includes: %v", includes)
+	}
+
+	if _, _, err := References("{{ if }}"); err == nil {
+		t.Error("expected parse error")
+	}
+}
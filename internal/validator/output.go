@@ -0,0 +1,282 @@
+package validator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/devaloi/promptkit/internal/frontmatter"
+)
+
+// Output schema rules reported in Violation.Rule, in addition to the
+// variable rules above. Properties rejected by additionalProperties: false
+// are reported with RuleUnknown.
+const (
+	RuleMinimum  = "minimum"
+	RuleMaximum  = "maximum"
+	RuleMinItems = "min_items"
+	RuleMaxItems = "max_items"
+)
+
+// ErrNoJSON is returned when a model response contains no JSON value.
+var ErrNoJSON = errors.New("response contains no JSON value")
+
+// Violation describes a single part of a model response that does not match
+// the output_schema.
+type Violation struct {
+	// Path locates the offending value as a JSON Pointer such as
+	// "/items/0/name"; it is empty for the response as a whole.
+	Path    string
+	Rule    string
+	Message string
+}
+
+func (v Violation) Error() string {
+	if v.Path == "" {
+		return "response: " + v.Message
+	}
+	return fmt.Sprintf("%s: %s", v.Path, v.Message)
+}
+
+// OutputError is returned when a model response does not match a template's
+// output_schema. It lists every violation.
+type OutputError struct {
+	Violations []Violation
+}
+
+func (e *OutputError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Error()
+	}
+	return fmt.Sprintf("response does not match output_schema: %s", strings.Join(msgs, "; "))
+}
+
+// ValidateOutput extracts the JSON value from a model response with
+// ExtractJSON and checks it against the output_schema declared in meta. It
+// returns the decoded value, and a *OutputError listing every violation if
+// the value does not match. Without an output_schema any JSON value is valid.
+func ValidateOutput(meta frontmatter.Metadata, response string) (any, error) {
+	value, err := ExtractJSON(response)
+	if err != nil {
+		return nil, err
+	}
+	if meta.OutputSchema == nil {
+		return value, nil
+	}
+
+	var violations []Violation
+	checkSchema("", meta.OutputSchema, value, &violations)
+	if len(violations) > 0 {
+		return value, &OutputError{Violations: violations}
+	}
+	return value, nil
+}
+
+// fencePattern matches Markdown code fences, optionally tagged as JSON.
+var fencePattern = regexp.MustCompile("(?s)```[ \t]*(?:json|JSON)?[ \t]*\r?\n(.*?)```")
+
+// ExtractJSON decodes the JSON value in a model response. The response may be
+// bare JSON, hold the value in a Markdown code fence, or surround it with
+// prose; in the last case the first object or array that decodes is used.
+// Returns ErrNoJSON if no value is found.
+func ExtractJSON(response string) (any, error) {
+	if value, err := decodeJSON(strings.TrimSpace(response), true); err == nil {
+		return value, nil
+	}
+
+	for _, m := range fencePattern.FindAllStringSubmatch(response, -1) {
+		if value, err := decodeJSON(strings.TrimSpace(m[1]), true); err == nil {
+			return value, nil
+		}
+	}
+
+	for i, r := range response {
+		if r != '{' && r != '[' {
+			continue
+		}
+		if value, err := decodeJSON(response[i:], false); err == nil {
+			return value, nil
+		}
+	}
+	return nil, ErrNoJSON
+}
+
+// decodeJSON decodes the JSON value at the start of text. If whole is set the
+// value must make up all of text.
+func decodeJSON(text string, whole bool) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if whole && dec.More() {
+		return nil, errors.New("trailing data after JSON value")
+	}
+	return value, nil
+}
+
+// checkSchema appends the violations of value against schema, located at
+// path, to violations.
+func checkSchema(path string, schema *frontmatter.Schema, value any, violations *[]Violation) {
+	add := func(rule, format string, args ...any) {
+		*violations = append(*violations, Violation{Path: path, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if schema.Type != "" {
+		ok, known := hasJSONType(schema.Type, value)
+		if !known {
+			add(RuleType, "unsupported schema type %q", schema.Type)
+			return
+		}
+		if !ok {
+			add(RuleType, "must be %s, got %s", schema.Type, jsonType(value))
+			return
+		}
+	}
+
+	if len(schema.Enum) > 0 && !jsonEnum(schema.Enum, value) {
+		choices := make([]string, len(schema.Enum))
+		for i, c := range schema.Enum {
+			choices[i] = fmt.Sprint(c)
+		}
+		add(RuleEnum, "must be one of %s, got %v", strings.Join(choices, ", "), value)
+	}
+
+	switch v := value.(type) {
+	case string:
+		if schema.Pattern != "" {
+			re, err := regexp.Compile(schema.Pattern)
+			switch {
+			case err != nil:
+				add(RulePattern, "invalid pattern %q: %v", schema.Pattern, err)
+			case !re.MatchString(v):
+				add(RulePattern, "must match pattern %q", schema.Pattern)
+			}
+		}
+		n := utf8.RuneCountInString(v)
+		if schema.MinLength != nil && n < *schema.MinLength {
+			add(RuleMinLength, "length %d is less than %d", n, *schema.MinLength)
+		}
+		if schema.MaxLength != nil && n > *schema.MaxLength {
+			add(RuleMaxLength, "length %d is greater than %d", n, *schema.MaxLength)
+		}
+
+	case float64:
+		if schema.Minimum != nil && v < *schema.Minimum {
+			add(RuleMinimum, "%v is less than %v", v, *schema.Minimum)
+		}
+		if schema.Maximum != nil && v > *schema.Maximum {
+			add(RuleMaximum, "%v is greater than %v", v, *schema.Maximum)
+		}
+
+	case []any:
+		if schema.MinItems != nil && len(v) < *schema.MinItems {
+			add(RuleMinItems, "has %d items, fewer than %d", len(v), *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(v) > *schema.MaxItems {
+			add(RuleMaxItems, "has %d items, more than %d", len(v), *schema.MaxItems)
+		}
+		if schema.Items != nil {
+			for i, item := range v {
+				checkSchema(path+"/"+strconv.Itoa(i), schema.Items, item, violations)
+			}
+		}
+
+	case map[string]any:
+		for _, name := range schema.Required {
+			if _, ok := v[name]; !ok {
+				*violations = append(*violations, Violation{Path: path + "/" + pointerEscape(name), Rule: RuleRequired, Message: "is required"})
+			}
+		}
+
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			child := path + "/" + pointerEscape(name)
+			prop, ok := schema.Properties[name]
+			switch {
+			case ok && prop != nil:
+				checkSchema(child, prop, v[name], violations)
+			case !ok && schema.AdditionalProperties != nil && !*schema.AdditionalProperties:
+				*violations = append(*violations, Violation{Path: child, Rule: RuleUnknown, Message: "is not a declared property"})
+			}
+		}
+	}
+}
+
+// hasJSONType reports whether value has the JSON Schema type typ, and whether
+// typ is a supported type at all.
+func hasJSONType(typ string, value any) (ok, known bool) {
+	switch typ {
+	case "object":
+		_, ok = value.(map[string]any)
+	case "array":
+		_, ok = value.([]any)
+	case "string":
+		_, ok = value.(string)
+	case "number":
+		_, ok = value.(float64)
+	case "integer":
+		f, isNum := value.(float64)
+		ok = isNum && f == math.Trunc(f)
+	case "boolean":
+		_, ok = value.(bool)
+	case "null":
+		ok = value == nil
+	default:
+		return false, false
+	}
+	return ok, true
+}
+
+// jsonType names the JSON type of a decoded value.
+func jsonType(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// jsonEnum reports whether value equals one of choices. Choices come from
+// YAML, so both sides are compared in their JSON form.
+func jsonEnum(choices []any, value any) bool {
+	for _, c := range choices {
+		data, err := json.Marshal(c)
+		if err != nil {
+			continue
+		}
+		var normalized any
+		if err := json.Unmarshal(data, &normalized); err == nil && reflect.DeepEqual(normalized, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// pointerEscape escapes a property name for use in a JSON Pointer.
+func pointerEscape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
@@ -0,0 +1,123 @@
+package validator
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/devaloi/promptkit/internal/frontmatter"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     any
+	}{
+		{"bare object", `{"a": 1}`, map[string]any{"a": 1.0}},
+		{"bare scalar", ` "yes" `, "yes"},
+		{"fenced", "Here you go:\n```json\n{\"a\": [true]}\n```\nAnything else?", map[string]any{"a": []any{true}}},
+		{"untagged fence", "```\n[1, 2]\n```", []any{1.0, 2.0}},
+		{"prose", `Sure! {"a": "b"} Hope that helps {not json}`, map[string]any{"a": "b"}},
+		{"skips braces in prose", `Use {curly} quotes: {"a": null}`, map[string]any{"a": nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractJSON(tt.response)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ExtractJSON("I cannot answer that {sorry}."); !errors.Is(err, ErrNoJSON) {
+		t.Errorf("expected ErrNoJSON, got %v", err)
+	}
+}
+
+func TestValidateOutput(t *testing.T) {
+	content := `---
+output_schema:
+  type: object
+  required: [category, confidence, tags]
+  additionalProperties: false
+  properties:
+    category:
+      type: string
+      enum: [tech, science]
+    confidence:
+      type: number
+      minimum: 0
+      maximum: 1
+    tags:
+      type: array
+      maxItems: 2
+      items:
+        type: string
+        minLength: 2
+    count:
+      type: integer
+---
+`
+	parsed, err := frontmatter.Parse(content)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	meta := parsed.Meta
+
+	value, err := ValidateOutput(meta, "```json\n{\"category\": \"tech\", \"confidence\": 0.9, \"tags\": [\"go\"], \"count\": 3}\n```")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value.(map[string]any)["category"] != "tech" {
+		t.Errorf("unexpected value: %v", value)
+	}
+
+	_, err = ValidateOutput(meta, `Result: {"category": "art", "confidence": 1.5, "tags": ["go", "x", "ai"], "count": 2.5, "extra": 1}`)
+	var outErr *OutputError
+	if !errors.As(err, &outErr) {
+		t.Fatalf("expected *OutputError, got %v", err)
+	}
+	var got []string
+	for _, v := range outErr.Violations {
+		got = append(got, v.Path+" "+v.Rule)
+	}
+	want := []string{
+		"/category enum",
+		"/confidence maximum",
+		"/count type",
+		"/extra unknown",
+		"/tags max_items",
+		"/tags/1 min_length",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected violations:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if !strings.Contains(err.Error(), "/count: must be integer, got number") {
+		t.Errorf("unexpected message: %v", err)
+	}
+
+	_, err = ValidateOutput(meta, `{"tags": []}`)
+	if !errors.As(err, &outErr) || len(outErr.Violations) != 2 || outErr.Violations[0].Path != "/category" || outErr.Violations[0].Rule != RuleRequired {
+		t.Errorf("expected missing required properties, got %v", err)
+	}
+
+	_, err = ValidateOutput(meta, `["not", "an", "object"]`)
+	if !errors.As(err, &outErr) || outErr.Violations[0].Error() != "response: must be object, got array" {
+		t.Errorf("expected root type violation, got %v", err)
+	}
+}
+
+func TestValidateOutput_NoSchema(t *testing.T) {
+	value, err := ValidateOutput(frontmatter.Metadata{}, "[1]")
+	if err != nil || !reflect.DeepEqual(value, []any{1.0}) {
+		t.Errorf("got %v, %v", value, err)
+	}
+	if _, err := ValidateOutput(frontmatter.Metadata{}, "no json"); !errors.Is(err, ErrNoJSON) {
+		t.Errorf("expected ErrNoJSON, got %v", err)
+	}
+}
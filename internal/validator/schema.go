@@ -0,0 +1,212 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/devaloi/promptkit/internal/frontmatter"
+)
+
+// Validation rules reported in FieldError.Rule.
+const (
+	RuleRequired  = "required"
+	RuleType      = "type"
+	RuleEnum      = "enum"
+	RulePattern   = "pattern"
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleUnknown   = "unknown"
+)
+
+// FieldError describes a single variable that failed validation.
+type FieldError struct {
+	Var     string
+	Rule    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Var, e.Message)
+}
+
+// VarsError is returned when variables do not satisfy a template's declared
+// requirements. It lists every failing field.
+type VarsError struct {
+	Errors []FieldError
+}
+
+func (e *VarsError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Error()
+	}
+	return fmt.Sprintf("invalid variables: %s", strings.Join(msgs, "; "))
+}
+
+// ValidateMeta checks vars against the required variables and the vars schema
+// declared in meta. Variables with a declared default are not required.
+// Returns a *VarsError listing every failing field, or nil if vars are valid.
+func ValidateMeta(meta frontmatter.Metadata, vars map[string]any) error {
+	var errs []FieldError
+
+	for _, name := range meta.Required() {
+		if _, ok := vars[name]; !ok {
+			errs = append(errs, FieldError{Var: name, Rule: RuleRequired, Message: "is required"})
+		}
+	}
+
+	names := make([]string, 0, len(meta.Vars))
+	for name := range meta.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, ok := vars[name]
+		if !ok {
+			continue
+		}
+		errs = append(errs, CheckValue(name, meta.Vars[name], value)...)
+	}
+
+	if len(errs) > 0 {
+		return &VarsError{Errors: errs}
+	}
+	return nil
+}
+
+// CheckValue validates a single value against spec. Strings are accepted for
+// int, float and bool variables when they parse as that type, since values
+// from the CLI and chain definitions are always strings.
+func CheckValue(name string, spec frontmatter.VarSpec, value any) []FieldError {
+	if err := checkType(spec.Type, value); err != "" {
+		return []FieldError{{Var: name, Rule: RuleType, Message: err}}
+	}
+
+	var errs []FieldError
+
+	if len(spec.Enum) > 0 && !inEnum(spec.Enum, value) {
+		choices := make([]string, len(spec.Enum))
+		for i, c := range spec.Enum {
+			choices[i] = fmt.Sprint(c)
+		}
+		errs = append(errs, FieldError{
+			Var:     name,
+			Rule:    RuleEnum,
+			Message: fmt.Sprintf("must be one of %s, got %v", strings.Join(choices, ", "), value),
+		})
+	}
+
+	if spec.Pattern != "" {
+		re, err := regexp.Compile(spec.Pattern)
+		switch {
+		case err != nil:
+			errs = append(errs, FieldError{Var: name, Rule: RulePattern, Message: fmt.Sprintf("invalid pattern %q: %v", spec.Pattern, err)})
+		case !re.MatchString(fmt.Sprint(value)):
+			errs = append(errs, FieldError{Var: name, Rule: RulePattern, Message: fmt.Sprintf("must match pattern %q", spec.Pattern)})
+		}
+	}
+
+	if spec.MinLength != nil || spec.MaxLength != nil {
+		n := length(value)
+		if spec.MinLength != nil && n < *spec.MinLength {
+			errs = append(errs, FieldError{Var: name, Rule: RuleMinLength, Message: fmt.Sprintf("length %d is less than %d", n, *spec.MinLength)})
+		}
+		if spec.MaxLength != nil && n > *spec.MaxLength {
+			errs = append(errs, FieldError{Var: name, Rule: RuleMaxLength, Message: fmt.Sprintf("length %d is greater than %d", n, *spec.MaxLength)})
+		}
+	}
+
+	return errs
+}
+
+// checkType returns a description of the mismatch, or "" if value has type typ.
+func checkType(typ string, value any) string {
+	rv := reflect.ValueOf(value)
+	kind := reflect.Invalid
+	if rv.IsValid() {
+		kind = rv.Kind()
+	}
+	s, isString := value.(string)
+
+	ok := true
+	switch typ {
+	case "", "any":
+	case frontmatter.TypeString:
+		ok = isString
+	case frontmatter.TypeInt:
+		switch {
+		case isString:
+			_, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+			ok = err == nil
+		case kind >= reflect.Int && kind <= reflect.Uint64:
+		case kind == reflect.Float32 || kind == reflect.Float64:
+			ok = rv.Float() == float64(int64(rv.Float()))
+		default:
+			ok = false
+		}
+	case frontmatter.TypeFloat:
+		switch {
+		case isString:
+			_, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			ok = err == nil
+		default:
+			ok = kind >= reflect.Int && kind <= reflect.Float64
+		}
+	case frontmatter.TypeBool:
+		if isString {
+			_, err := strconv.ParseBool(strings.TrimSpace(s))
+			ok = err == nil
+		} else {
+			ok = kind == reflect.Bool
+		}
+	case frontmatter.TypeList:
+		ok = kind == reflect.Slice || kind == reflect.Array
+	case frontmatter.TypeObject:
+		ok = kind == reflect.Map || kind == reflect.Struct
+	default:
+		return fmt.Sprintf("unknown type %q", typ)
+	}
+
+	if !ok {
+		return fmt.Sprintf("expected %s, got %v", typ, describe(value))
+	}
+	return ""
+}
+
+func describe(value any) string {
+	if s, ok := value.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func inEnum(choices []any, value any) bool {
+	v := fmt.Sprint(value)
+	for _, c := range choices {
+		if fmt.Sprint(c) == v {
+			return true
+		}
+	}
+	return false
+}
+
+// length returns the rune count of strings and the element count of
+// collections; other values are measured by their string form.
+func length(value any) int {
+	if s, ok := value.(string); ok {
+		return utf8.RuneCountInString(s)
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len()
+	default:
+		return utf8.RuneCountInString(fmt.Sprint(value))
+	}
+}
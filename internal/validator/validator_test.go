@@ -3,6 +3,8 @@ package validator
 import (
 	"errors"
 	"testing"
+
+	"github.com/devaloi/promptkit/internal/frontmatter"
 )
 
 func TestValidate_AllPresent(t *testing.T) {
@@ -72,3 +74,97 @@ func TestMissingVarsError_Message(t *testing.T) {
 		t.Errorf("expected %q, got %q", expected, e.Error())
 	}
 }
+
+func intPtr(n int) *int { return &n }
+
+func TestValidateMeta_Valid(t *testing.T) {
+	meta := frontmatter.Metadata{
+		RequiredVars: []string{"document"},
+		Vars: map[string]frontmatter.VarSpec{
+			"max_words": {Type: frontmatter.TypeInt, Default: 100},
+			"tone":      {Type: frontmatter.TypeString, Enum: []any{"formal", "casual"}},
+		},
+	}
+	vars := map[string]any{"document": "text", "max_words": "50", "tone": "casual"}
+
+	if err := ValidateMeta(meta, vars); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestValidateMeta_FieldErrors(t *testing.T) {
+	meta := frontmatter.Metadata{
+		RequiredVars: []string{"document"},
+		Vars: map[string]frontmatter.VarSpec{
+			"max_words": {Type: frontmatter.TypeInt},
+			"tone":      {Enum: []any{"formal", "casual"}},
+			"code":      {Type: frontmatter.TypeString, Pattern: `^[A-Z]{3}$`},
+			"tags":      {Type: frontmatter.TypeList, MaxLength: intPtr(2)},
+			"title":     {MinLength: intPtr(3)},
+		},
+	}
+	vars := map[string]any{
+		"max_words": "banana",
+		"tone":      "angry",
+		"code":      "abc",
+		"tags":      []string{"a", "b", "c"},
+		"title":     "é",
+	}
+
+	err := ValidateMeta(meta, vars)
+
+	var ve *VarsError
+	if !errors.As(err, &ve) {
+		t.Fatalf("expected *VarsError, got %T (%v)", err, err)
+	}
+
+	got := make(map[string]string, len(ve.Errors))
+	for _, fe := range ve.Errors {
+		got[fe.Var] = fe.Rule
+	}
+	expected := map[string]string{
+		"document":  RuleRequired,
+		"max_words": RuleType,
+		"tone":      RuleEnum,
+		"code":      RulePattern,
+		"tags":      RuleMaxLength,
+		"title":     RuleMinLength,
+	}
+	for name, rule := range expected {
+		if got[name] != rule {
+			t.Errorf("%s: expected rule %q, got %q", name, rule, got[name])
+		}
+	}
+	if len(ve.Errors) != len(expected) {
+		t.Errorf("expected %d errors, got %d: %v", len(expected), len(ve.Errors), ve.Errors)
+	}
+}
+
+func TestCheckValue_Types(t *testing.T) {
+	tests := []struct {
+		typ   string
+		value any
+		ok    bool
+	}{
+		{frontmatter.TypeString, "x", true},
+		{frontmatter.TypeString, 1, false},
+		{frontmatter.TypeInt, 3, true},
+		{frontmatter.TypeInt, "42", true},
+		{frontmatter.TypeInt, 1.5, false},
+		{frontmatter.TypeFloat, "0.5", true},
+		{frontmatter.TypeFloat, true, false},
+		{frontmatter.TypeBool, "true", true},
+		{frontmatter.TypeBool, "yes", false},
+		{frontmatter.TypeList, []any{1}, true},
+		{frontmatter.TypeList, "a,b", false},
+		{frontmatter.TypeObject, map[string]any{}, true},
+		{frontmatter.TypeObject, nil, false},
+		{"uuid", "x", false},
+	}
+	for _, tt := range tests {
+		errs := CheckValue("v", frontmatter.VarSpec{Type: tt.typ}, tt.value)
+		if (len(errs) == 0) != tt.ok {
+			t.Errorf("CheckValue(%s, %#v): expected ok=%v, got %v", tt.typ, tt.value, tt.ok, errs)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+// Package promptkit is the public Go API for the promptkit LLM prompt template
+// engine.
+//
+// It exposes the template registry, rendering, validation and chain execution
+// used by the promptkit CLI so that Go services can load and render prompts
+// in-process instead of shelling out:
+//
+//	reg, err := promptkit.LoadDir("templates")
+//	if err != nil {
+//		return err
+//	}
+//	result, err := reg.Render("summarize", vars)
+//
+// The identifiers declared in this package form the supported surface and
+// follow semantic versioning (see Version); packages under internal/ may
+// change without notice.
+package promptkit
+
+import (
+	"context"
+	"io/fs"
+	"text/template"
+
+	"github.com/devaloi/promptkit/internal/chain"
+	"github.com/devaloi/promptkit/internal/config"
+	"github.com/devaloi/promptkit/internal/engine"
+	"github.com/devaloi/promptkit/internal/frontmatter"
+	"github.com/devaloi/promptkit/internal/provider"
+	"github.com/devaloi/promptkit/internal/registry"
+	"github.com/devaloi/promptkit/internal/tokenizer"
+	"github.com/devaloi/promptkit/internal/validator"
+)
+
+// Version is the semantic version of the public promptkit API.
+const Version = "0.2.0"
+
+// DefaultTemplateDir is the default directory for template files.
+const DefaultTemplateDir = config.DefaultTemplateDir
+
+type (
+	// Registry holds loaded templates indexed by name.
+	Registry = registry.Registry
+
+	// Template is a loaded template file with its metadata and raw content.
+	Template = registry.Template
+
+	// Include is a loaded include file with its metadata and raw content.
+	Include = registry.Include
+
+	// RegistryOptions configures a Registry.
+	RegistryOptions = registry.Options
+
+	// Diagnostic describes a problem with a single file found while loading
+	// templates.
+	Diagnostic = registry.Diagnostic
+
+	// LoadError is returned when loading templates finds one or more
+	// problems; it unwraps to the individual diagnostics.
+	LoadError = registry.LoadError
+
+	// FrontmatterError reports frontmatter that is not valid YAML.
+	FrontmatterError = frontmatter.ParseError
+
+	// Layer is a source of templates in a layered registry.
+	Layer = registry.Layer
+
+	// ReloadEvent reports the outcome of a reload triggered by
+	// Registry.Watch.
+	ReloadEvent = registry.ReloadEvent
+
+	// Metadata holds parsed YAML frontmatter fields from a template file.
+	Metadata = frontmatter.Metadata
+
+	// Frontmatter contains parsed frontmatter metadata and the remaining
+	// template body.
+	Frontmatter = frontmatter.Result
+
+	// RenderResult holds the output of rendering a template.
+	RenderResult = engine.RenderResult
+
+	// RenderOptions controls how a template is rendered.
+	RenderOptions = engine.Options
+
+	// RenderError reports a template execution failure with the template
+	// name, line and column of the offending action.
+	RenderError = engine.RenderError
+
+	// BudgetError is returned when a prompt cannot be trimmed to fit its
+	// token_budget.
+	BudgetError = engine.BudgetError
+
+	// Message is a single role-tagged chat message.
+	Message = engine.Message
+
+	// Role identifies the author of a chat message.
+	Role = engine.Role
+
+	// Chain is a parsed chain definition.
+	Chain = chain.Definition
+
+	// ChainStep is a single step in a chain.
+	ChainStep = chain.Step
+
+	// ChainResult holds the outputs from executing a chain.
+	ChainResult = chain.Result
+
+	// ChainOptions controls how a chain is executed.
+	ChainOptions = chain.Options
+
+	// ChainStepResult records what a single chain step sent to the model and
+	// received.
+	ChainStepResult = chain.StepResult
+
+	// Provider completes chat prompts with a language model.
+	Provider = provider.Provider
+
+	// ProviderFunc adapts an ordinary function to the Provider interface.
+	ProviderFunc = provider.Func
+
+	// ProviderRequest is a rendered prompt ready to be sent to a model.
+	ProviderRequest = provider.Request
+
+	// ProviderResponse is a model's reply to a ProviderRequest.
+	ProviderResponse = provider.Response
+
+	// ProviderUsage reports the tokens a request consumed.
+	ProviderUsage = provider.Usage
+
+	// APIError is an error response from a provider's API.
+	APIError = provider.APIError
+
+	// OpenAIConfig configures an OpenAI provider.
+	OpenAIConfig = provider.OpenAIConfig
+
+	// OpenAIProvider is a Provider for the OpenAI chat completions API and
+	// compatible servers.
+	OpenAIProvider = provider.OpenAI
+
+	// AnthropicConfig configures an Anthropic provider.
+	AnthropicConfig = provider.AnthropicConfig
+
+	// AnthropicProvider is a Provider for the Anthropic Messages API.
+	AnthropicProvider = provider.Anthropic
+
+	// Recorder is a Provider that records the requests and responses of
+	// another provider to a cassette file.
+	Recorder = provider.Recorder
+
+	// Replayer is a Provider that serves responses from a cassette file.
+	Replayer = provider.Replayer
+
+	// Interaction is a recorded request and the response it received.
+	Interaction = provider.Interaction
+
+	// VarSpec declares the type, default and constraints of a template
+	// variable.
+	VarSpec = frontmatter.VarSpec
+
+	// Generation holds the model settings a template is meant to be sent
+	// with, from the generation frontmatter section.
+	Generation = frontmatter.Generation
+
+	// OutputSchema is the subset of JSON Schema accepted in the
+	// output_schema frontmatter field.
+	OutputSchema = frontmatter.Schema
+
+	// OutputError lists the ways a model response does not match a
+	// template's output_schema.
+	OutputError = validator.OutputError
+
+	// Violation describes a single part of a model response that does not
+	// match the output_schema.
+	Violation = validator.Violation
+
+	// MissingVarsError is returned when required variables are not provided.
+	MissingVarsError = validator.MissingVarsError
+
+	// VarsError lists every variable that failed validation.
+	VarsError = validator.VarsError
+
+	// FieldError describes a single variable that failed validation.
+	FieldError = validator.FieldError
+
+	// Tokenizer splits text into model tokens.
+	Tokenizer = tokenizer.Tokenizer
+
+	// TokenizerSet maps model name prefixes to tokenizers; see
+	// RenderOptions.Tokenizers.
+	TokenizerSet = tokenizer.Set
+
+	// HeuristicTokenizer estimates ~4 bytes per token. It is the fallback
+	// when no tokenizer matches a template's model_hint.
+	HeuristicTokenizer = tokenizer.Heuristic
+
+	// Usage describes the variables and templates a template body references.
+	Usage = validator.Usage
+
+	// LintResult reports undeclared and unused template variables.
+	LintResult = validator.LintResult
+
+	// Graph is the dependency graph of templates, includes and chains.
+	Graph = registry.Graph
+
+	// GraphNode is a template, include or chain in a Graph.
+	GraphNode = registry.Node
+
+	// GraphEdge links a GraphNode to a node it depends on.
+	GraphEdge = registry.Edge
+)
+
+// Chat message roles produced by the {{ system }}, {{ user }} and
+// {{ assistant }} role blocks.
+const (
+	RoleSystem    = engine.RoleSystem
+	RoleUser      = engine.RoleUser
+	RoleAssistant = engine.RoleAssistant
+)
+
+// Kinds of problems reported in a Diagnostic.
+const (
+	DiagnosticUnreadable       = registry.KindUnreadable
+	DiagnosticFrontmatter      = registry.KindFrontmatter
+	DiagnosticDuplicateName    = registry.KindDuplicateName
+	DiagnosticIncludeCollision = registry.KindIncludeCollision
+	DiagnosticCompile          = registry.KindCompile
+	DiagnosticExtends          = registry.KindExtends
+	DiagnosticVersion          = registry.KindVersion
+)
+
+// DefaultChainWorkers is the number of independent chain steps run at once
+// when ChainOptions.Workers is not set.
+const DefaultChainWorkers = chain.DefaultWorkers
+
+// Kinds of nodes in a Graph.
+const (
+	GraphTemplate = registry.NodeTemplate
+	GraphInclude  = registry.NodeInclude
+	GraphChain    = registry.NodeChain
+)
+
+// Kinds of edges in a Graph.
+const (
+	GraphUses    = registry.EdgeUses
+	GraphExtends = registry.EdgeExtends
+	GraphStep    = registry.EdgeStep
+)
+
+// ErrNoFrontmatter indicates the template has no YAML frontmatter delimiters.
+var ErrNoFrontmatter = frontmatter.ErrNoFrontmatter
+
+// ErrNoJSON is returned when a model response contains no JSON value.
+var ErrNoJSON = validator.ErrNoJSON
+
+// ErrUnrecorded is returned by a Replayer for a request its cassette does
+// not contain.
+var ErrUnrecorded = provider.ErrUnrecorded
+
+// ErrNoProvider is returned when a chain is executed without a Provider
+// outside a dry run.
+var ErrNoProvider = chain.ErrNoProvider
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return registry.New()
+}
+
+// NewRegistryWithOptions creates an empty Registry configured by opts.
+func NewRegistryWithOptions(opts RegistryOptions) *Registry {
+	return registry.NewWithOptions(opts)
+}
+
+// LoadDir creates a Registry and loads all templates from dir into it.
+func LoadDir(dir string) (*Registry, error) {
+	reg := registry.New()
+	if err := reg.LoadDir(dir); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+// Layered creates a Registry composed of layers, where templates and includes
+// in later layers shadow those with the same name in earlier ones:
+//
+//	reg, err := promptkit.Layered(
+//		promptkit.DirLayer("prompts/base"),
+//		promptkit.DirLayer("prompts/acme"),
+//	)
+func Layered(layers ...Layer) (*Registry, error) {
+	return registry.Layered(layers...)
+}
+
+// DirLayer returns a layer that reads templates from dir, named after dir.
+func DirLayer(dir string) Layer {
+	return registry.DirLayer(dir)
+}
+
+// LoadFS creates a Registry and loads all templates from the root directory
+// of fsys into it. Use it with embed.FS to ship templates inside a binary:
+//
+//	//go:embed templates
+//	var templates embed.FS
+//
+//	reg, err := promptkit.LoadFS(templates, "templates")
+func LoadFS(fsys fs.FS, root string) (*Registry, error) {
+	reg := registry.New()
+	if err := reg.LoadFS(fsys, root); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+// ParseFrontmatter splits template content into frontmatter metadata and body.
+// ErrNoFrontmatter is returned when content has no frontmatter block.
+func ParseFrontmatter(content string) (Frontmatter, error) {
+	return frontmatter.Parse(content)
+}
+
+// Render renders template content with vars and optional include templates.
+func Render(content string, vars map[string]any, includes map[string]string) (RenderResult, error) {
+	return engine.Render(content, vars, includes)
+}
+
+// RenderWithOptions renders template content like Render using opts.
+func RenderWithOptions(content string, vars map[string]any, includes map[string]string, opts RenderOptions) (RenderResult, error) {
+	return engine.RenderWithOptions(content, vars, includes, opts)
+}
+
+// RenderMessages renders template content and splits the output into
+// role-tagged chat messages.
+func RenderMessages(content string, vars map[string]any, includes map[string]string) (RenderResult, error) {
+	return engine.RenderMessages(content, vars, includes)
+}
+
+// RenderMessagesWithOptions renders template content like RenderMessages
+// using opts.
+func RenderMessagesWithOptions(content string, vars map[string]any, includes map[string]string, opts RenderOptions) (RenderResult, error) {
+	return engine.RenderMessagesWithOptions(content, vars, includes, opts)
+}
+
+// FuncMap returns the helper functions available to every template.
+func FuncMap() template.FuncMap {
+	return engine.FuncMap()
+}
+
+// Validate checks that all requiredVars are present as keys in vars.
+func Validate(requiredVars []string, vars map[string]any) error {
+	return validator.Validate(requiredVars, vars)
+}
+
+// ValidateMeta checks vars against the required variables and vars schema
+// declared in meta, returning a *VarsError listing every failing field.
+func ValidateMeta(meta Metadata, vars map[string]any) error {
+	return validator.ValidateMeta(meta, vars)
+}
+
+// ValidateOutput extracts the JSON value from a model response, tolerating
+// code fences and surrounding prose, and checks it against the output_schema
+// declared in meta. It returns the decoded value and a *OutputError listing
+// every violation.
+func ValidateOutput(meta Metadata, response string) (any, error) {
+	return validator.ValidateOutput(meta, response)
+}
+
+// ExtractJSON decodes the JSON value in a model response.
+func ExtractJSON(response string) (any, error) {
+	return validator.ExtractJSON(response)
+}
+
+// NewOpenAIProvider creates a Provider for the OpenAI chat completions API
+// or a compatible server. An empty BaseURL is read from OPENAI_BASE_URL,
+// defaulting to the OpenAI API, and an empty APIKey from OPENAI_API_KEY.
+func NewOpenAIProvider(cfg OpenAIConfig) *OpenAIProvider {
+	return provider.NewOpenAI(cfg)
+}
+
+// NewAnthropicProvider creates a Provider for the Anthropic Messages API. An
+// empty BaseURL is read from ANTHROPIC_BASE_URL, defaulting to the Anthropic
+// API, and an empty APIKey from ANTHROPIC_API_KEY.
+func NewAnthropicProvider(cfg AnthropicConfig) *AnthropicProvider {
+	return provider.NewAnthropic(cfg)
+}
+
+// NewRecorder creates a Recorder that records the interactions of p to the
+// cassette file at path, replacing any existing cassette.
+func NewRecorder(p Provider, path string) *Recorder {
+	return provider.NewRecorder(p, path)
+}
+
+// NewReplayer creates a Replayer serving the cassette file at path.
+func NewReplayer(path string) (*Replayer, error) {
+	return provider.NewReplayer(path)
+}
+
+// CassetteKey returns the key identifying req in a cassette: a hash of its
+// template, messages and generation settings.
+func CassetteKey(req ProviderRequest) string {
+	return provider.Key(req)
+}
+
+// LoadTokenizer loads a tokenizer vocabulary from disk: a tiktoken rank file
+// (".tiktoken") or a SentencePiece model (".model").
+func LoadTokenizer(path string) (Tokenizer, error) {
+	return tokenizer.Load(path)
+}
+
+// Analyze returns the top-level variables and templates referenced by a
+// template body and the includes it invokes.
+func Analyze(body string, includes map[string]string) (Usage, error) {
+	return validator.Analyze(body, includes)
+}
+
+// Lint compares the variables declared in meta with those referenced by body
+// and its includes.
+func Lint(meta Metadata, body string, includes map[string]string) (LintResult, error) {
+	return validator.Lint(meta, body, includes)
+}
+
+// LintInherited lints a template that extends others, given the bodies of its
+// extends chain from the root ancestor to the template itself (see
+// Registry.Lineage).
+func LintInherited(meta Metadata, bodies []string, includes map[string]string) (LintResult, error) {
+	return validator.LintInherited(meta, bodies, includes)
+}
+
+// ParseChain parses a chain definition from YAML bytes.
+func ParseChain(data []byte) (Chain, error) {
+	return chain.Parse(data)
+}
+
+// ParseChainFile reads and parses a chain definition from a YAML file.
+func ParseChainFile(path string) (Chain, error) {
+	return chain.ParseFile(path)
+}
+
+// ExecuteChain renders a chain against reg as a dry run, starting from
+// initialVars; each output_var holds a rendered prompt. Use
+// ExecuteChainContext to send the prompts to a model.
+func ExecuteChain(def Chain, reg *Registry, initialVars map[string]any) (ChainResult, error) {
+	return chain.Execute(def, reg, initialVars)
+}
+
+// ExecuteChainWithOptions runs a chain like ExecuteChainContext without a
+// deadline.
+func ExecuteChainWithOptions(def Chain, reg *Registry, initialVars map[string]any, opts ChainOptions) (ChainResult, error) {
+	return chain.ExecuteWithOptions(def, reg, initialVars, opts)
+}
+
+// ExecuteChainContext runs a chain against reg, starting from initialVars.
+// Each step's rendered messages are sent to opts.Provider and the response
+// is captured in the step's output_var, unless opts.DryRun is set.
+func ExecuteChainContext(ctx context.Context, def Chain, reg *Registry, initialVars map[string]any, opts ChainOptions) (ChainResult, error) {
+	return chain.ExecuteContext(ctx, def, reg, initialVars, opts)
+}
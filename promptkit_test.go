@@ -0,0 +1,141 @@
+package promptkit_test
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/devaloi/promptkit"
+)
+
+//go:embed templates
+var embedded embed.FS
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setupTemplates(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	incDir := filepath.Join(dir, "includes")
+	if err := os.Mkdir(incDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(incDir, "sig.tmpl"), "-- promptkit")
+
+	writeFile(t, filepath.Join(dir, "greet.tmpl"), `---
+name: greet
+description: A greeting
+required_vars:
+  - name
+---
+Hello, {{ .name }}!
+{{ template "sig" }}`)
+
+	return dir
+}
+
+func TestLoadDirAndRender(t *testing.T) {
+	reg, err := promptkit.LoadDir(setupTemplates(t))
+	if err != nil {
+		t.Fatalf("LoadDir error: %v", err)
+	}
+
+	tmpl, err := reg.Get("greet")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+
+	vars := map[string]any{"name": "Go"}
+	if err := promptkit.Validate(tmpl.Meta.RequiredVars, vars); err != nil {
+		t.Fatalf("Validate error: %v", err)
+	}
+
+	result, err := promptkit.Render(tmpl.Content, vars, reg.Includes())
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if result.Output != "Hello, Go!\n-- promptkit" {
+		t.Errorf("unexpected output: %q", result.Output)
+	}
+}
+
+func TestValidate_MissingVarsError(t *testing.T) {
+	err := promptkit.Validate([]string{"name"}, nil)
+
+	var mve *promptkit.MissingVarsError
+	if !errors.As(err, &mve) {
+		t.Fatalf("expected *MissingVarsError, got %T", err)
+	}
+}
+
+func TestExecuteChain(t *testing.T) {
+	reg, err := promptkit.LoadDir(setupTemplates(t))
+	if err != nil {
+		t.Fatalf("LoadDir error: %v", err)
+	}
+
+	def, err := promptkit.ParseChain([]byte(`name: greet-chain
+steps:
+  - template: greet
+    vars:
+      name: "{{ .who }}"
+    output_var: greeting
+`))
+	if err != nil {
+		t.Fatalf("ParseChain error: %v", err)
+	}
+
+	result, err := promptkit.ExecuteChain(def, reg, map[string]any{"who": "chains"})
+	if err != nil {
+		t.Fatalf("ExecuteChain error: %v", err)
+	}
+	if result.Intermediates["greeting"] != "Hello, chains!\n-- promptkit" {
+		t.Errorf("unexpected greeting: %q", result.Intermediates["greeting"])
+	}
+}
+
+func TestExecuteChainContext(t *testing.T) {
+	reg, err := promptkit.LoadDir(setupTemplates(t))
+	if err != nil {
+		t.Fatalf("LoadDir error: %v", err)
+	}
+	def := promptkit.Chain{Steps: []promptkit.ChainStep{{Template: "greet", Vars: map[string]string{"name": "{{ .who }}"}}}}
+
+	model := promptkit.ProviderFunc(func(_ context.Context, req promptkit.ProviderRequest) (promptkit.ProviderResponse, error) {
+		return promptkit.ProviderResponse{Content: "Hi back to " + req.Template}, nil
+	})
+	result, err := promptkit.ExecuteChainContext(context.Background(), def, reg, map[string]any{"who": "chains"}, promptkit.ChainOptions{Provider: model})
+	if err != nil {
+		t.Fatalf("ExecuteChainContext error: %v", err)
+	}
+	if result.Final != "Hi back to greet" {
+		t.Errorf("unexpected final output: %q", result.Final)
+	}
+}
+
+func TestLoadFS_Embed(t *testing.T) {
+	reg, err := promptkit.LoadFS(embedded, "templates")
+	if err != nil {
+		t.Fatalf("LoadFS error: %v", err)
+	}
+
+	result, err := reg.RenderMessages("classify", map[string]any{
+		"text":       "The package arrived broken.",
+		"categories": "complaint, praise",
+	})
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if len(result.Messages) != 2 || result.Messages[0].Role != promptkit.RoleSystem {
+		t.Errorf("unexpected messages: %+v", result.Messages)
+	}
+}